@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported aggregation intervals in seconds
+var AGGREGATE_INTERVALS = map[string]int{
+	"1m":  60,
+	"15m": 15 * 60,
+	"1h":  60 * 60,
+	"1d":  24 * 60 * 60,
+}
+
+// Statistics computed per numeric column and bucket
+var AGGREGATE_STATS = []string{"min", "avg", "max"}
+
+// AggregateSpec describes a time-bucketed export
+type AggregateSpec struct {
+	Interval     int           // bucket size in seconds
+	Metrics      []TableColumn // numeric columns, in the profile's column order
+	FaultColumns []TableColumn // columns counted like extractFaults
+}
+
+// Build an aggregation spec for a table. Numeric columns get min/avg/max,
+// fault flags are counted per bucket, and TINYINT fault flags are only counted.
+func buildAggregateSpec(interval string, tableColumns []TableColumn, selection *ColumnSelection, profile *HeaderProfile) (*AggregateSpec, error) {
+	seconds, err := aggregateInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &AggregateSpec{Interval: seconds}
+	includeFaults := selection == nil || selection.Output["Faults"]
+
+	byName := make(map[string]TableColumn)
+	var numericKeys []string
+	for _, column := range tableColumns {
+		if column.Name == "id" || column.Name == "created_at" {
+			continue
+		}
+
+		isFault := looksLikeFaultKey(column.Name)
+		if isFault && includeFaults {
+			spec.FaultColumns = append(spec.FaultColumns, column)
+		}
+		if !column.isNumeric() || (isFault && strings.EqualFold(column.DataType, "tinyint")) {
+			continue
+		}
+		if selection != nil && !selection.Output[column.Name] {
+			continue
+		}
+
+		byName[column.Name] = column
+		numericKeys = append(numericKeys, column.Name)
+	}
+
+	for _, key := range orderNumericColumns(numericKeys, profile.columnOrder()) {
+		spec.Metrics = append(spec.Metrics, byName[key])
+	}
+
+	return spec, nil
+}
+
+// Get the length in seconds of a supported interval such as "15m"
+func aggregateInterval(interval string) (int, error) {
+	seconds, ok := AGGREGATE_INTERVALS[interval]
+	if !ok {
+		names := make([]string, 0, len(AGGREGATE_INTERVALS))
+		for name := range AGGREGATE_INTERVALS {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return AGGREGATE_INTERVALS[names[i]] < AGGREGATE_INTERVALS[names[j]] })
+		return 0, &RequestError{Message: "Invalid aggregate interval", Details: "Supported intervals: " + strings.Join(names, ", ")}
+	}
+	return seconds, nil
+}
+
+// SQL expression for the bucket start, aligned to midnight in the display
+// zone. Buckets come back as display zone wall-clock time.
+func (a *AggregateSpec) bucketExpr(zones exportZones) string {
+	at := zones.displayCreatedAtExpr()
+	return fmt.Sprintf("DATE_ADD(DATE(%s), INTERVAL FLOOR(TIME_TO_SEC(%s) / %d) * %d SECOND)", at, at, a.Interval, a.Interval)
+}
+
+// SQL condition that is 1 when a fault column is active, matching isTrueish
+func faultActiveExpr(column TableColumn) string {
+	if column.isNumeric() {
+		return fmt.Sprintf("COALESCE(`%s` = 1, 0)", column.Name)
+	}
+	return fmt.Sprintf("COALESCE(LOWER(`%s`) IN ('true', '1'), 0)", column.Name)
+}
+
+// Build the aggregation query
+func (a *AggregateSpec) selectQuery(q ExportQuery) string {
+	fields := []string{a.bucketExpr(q.Zones) + " AS bucket", "COUNT(*) AS row_count"}
+
+	for _, column := range a.Metrics {
+		for _, stat := range AGGREGATE_STATS {
+			fields = append(fields, fmt.Sprintf("%s(`%s`)", strings.ToUpper(stat), column.Name))
+		}
+	}
+
+	if len(a.FaultColumns) > 0 {
+		active := make([]string, len(a.FaultColumns))
+		for i, column := range a.FaultColumns {
+			active[i] = faultActiveExpr(column)
+			fields = append(fields, "SUM("+active[i]+")")
+		}
+		// GREATEST needs at least two arguments
+		anyActive := active[0]
+		if len(active) > 1 {
+			anyActive = "GREATEST(" + strings.Join(active, ", ") + ")"
+		}
+		fields = append(fields, "SUM("+anyActive+")")
+	}
+
+	orderClause := "DESC"
+	if strings.ToLower(q.Order) == "asc" {
+		orderClause = "ASC"
+	}
+
+	return fmt.Sprintf("SELECT %s FROM `%s`%s GROUP BY bucket ORDER BY bucket %s",
+		strings.Join(fields, ", "), q.Table, q.WhereClause, orderClause)
+}
+
+// Get output headers for an aggregated export
+func (a *AggregateSpec) headers(pretty bool) []string {
+	headers := []string{"created_at"}
+	if pretty {
+		headers = append(headers, CREATED_AT_DERIVED...)
+	}
+	headers = append(headers, "row_count")
+
+	for _, column := range a.Metrics {
+		for _, stat := range AGGREGATE_STATS {
+			headers = append(headers, aggregateKey(column.Name, stat))
+		}
+	}
+
+	if len(a.FaultColumns) > 0 {
+		headers = append(headers, "fault_rows", "Faults")
+	}
+	return headers
+}
+
+// Get the MySQL type of each aggregated column: bucket starts are DATETIME,
+// counts BIGINT and statistics DOUBLE. Derived date, time and Faults columns
+// are text.
+func (a *AggregateSpec) columnTypes() map[string]string {
+	types := map[string]string{"created_at": "DATETIME", "row_count": "BIGINT", "fault_rows": "BIGINT"}
+	for _, column := range a.Metrics {
+		for _, stat := range AGGREGATE_STATS {
+			types[aggregateKey(column.Name, stat)] = "DOUBLE"
+		}
+	}
+	return types
+}
+
+// Key of a per-bucket statistic. The "__" separator cannot clash with real
+// columns such as Running_hours_min.
+func aggregateKey(column, stat string) string {
+	return column + "__" + stat
+}
+
+// Count the buckets an aggregated export will produce
+func countBuckets(ctx context.Context, tx *sql.Tx, q ExportQuery) (int, error) {
+	query := fmt.Sprintf("SELECT COUNT(DISTINCT %s) FROM `%s`%s", q.Aggregate.bucketExpr(q.Zones), q.Table, q.WhereClause)
+
+	var count int
+	err := tx.QueryRowContext(ctx, query, q.Params...).Scan(&count)
+	return count, err
+}
+
+// Stream one row per bucket through a RowWriter
+func writeAggregateExport(ctx context.Context, w RowWriter, tx *sql.Tx, q ExportQuery) (int, error) {
+	defer w.Close()
+
+	a := q.Aggregate
+	rows, err := tx.QueryContext(ctx, a.selectQuery(q), q.Params...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying buckets: %v", err)
+	}
+	defer rows.Close()
+
+	headers := a.headers(q.Pretty)
+	if tw, ok := w.(columnTypeSetter); ok {
+		tw.SetColumnTypes(a.columnTypes())
+	}
+	if lw, ok := w.(locationSetter); ok {
+		lw.SetLocation(q.Zones.Display)
+	}
+	if err := w.WriteHeader(headers); err != nil {
+		return 0, err
+	}
+
+	statCount := len(a.Metrics) * len(AGGREGATE_STATS)
+	faultCount := len(a.FaultColumns)
+	values := make([]interface{}, 2+statCount+faultCount)
+	if faultCount > 0 {
+		values = append(values, nil)
+	}
+	valuePtrs := make([]interface{}, len(values))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return rowCount, err
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return rowCount, err
+		}
+
+		createdAt := normalizeCreatedAt(q.Zones.displayed().toDisplay(values[0]))
+		row := DataRow{
+			"created_at": createdAt["full"],
+			"row_count":  toNum(aggregateValue(values[1])),
+		}
+		if q.Pretty {
+			row["created_at_date"] = createdAt["date"]
+			row["created_at_time"] = createdAt["time"]
+		}
+
+		i := 2
+		for _, column := range a.Metrics {
+			for _, stat := range AGGREGATE_STATS {
+				row[aggregateKey(column.Name, stat)] = toNum(aggregateValue(values[i]))
+				i++
+			}
+		}
+
+		if faultCount > 0 {
+			var faults []string
+			for _, column := range a.FaultColumns {
+				if n, _ := strconv.Atoi(aggregateValue(values[i])); n > 0 {
+					faults = append(faults, fmt.Sprintf("%s (%d)", strings.ReplaceAll(column.Name, "_", " "), n))
+				}
+				i++
+			}
+			row["fault_rows"] = toNum(aggregateValue(values[i]))
+			row["Faults"] = strings.Join(faults, ", ")
+		}
+
+		if err := w.WriteRow(row); err != nil {
+			return rowCount, err
+		}
+		rowCount++
+	}
+	if err := rows.Err(); err != nil {
+		return rowCount, err
+	}
+
+	if err := writeCharts(w, q); err != nil {
+		return rowCount, err
+	}
+
+	return rowCount, w.Flush()
+}
+
+// Convert an aggregate result to a string for toNum. MySQL returns AVG and
+// SUM of DECIMAL columns as bytes.
+func aggregateValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(val)
+	default:
+		return formatTextValue(val)
+	}
+}
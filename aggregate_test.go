@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestBuildAggregateSpec(t *testing.T) {
+	tableColumns := []TableColumn{
+		{Name: "id", DataType: "int"},
+		{Name: "created_at", DataType: "datetime"},
+		{Name: "HP_value", DataType: "decimal"},
+		{Name: "LP_value", DataType: "decimal"},
+		{Name: "Fault_code", DataType: "int"},
+		{Name: "door_open", DataType: "tinyint"},
+		{Name: "note", DataType: "varchar"},
+	}
+
+	spec, err := buildAggregateSpec("15m", tableColumns, nil, nil)
+	if err != nil {
+		t.Fatalf("buildAggregateSpec() error: %v", err)
+	}
+	if spec.Interval != 900 {
+		t.Errorf("Interval = %d, expected 900", spec.Interval)
+	}
+
+	expectedHeaders := []string{
+		"created_at", "created_at_date", "created_at_time", "row_count",
+		"LP_value__min", "LP_value__avg", "LP_value__max",
+		"HP_value__min", "HP_value__avg", "HP_value__max",
+		"Fault_code__min", "Fault_code__avg", "Fault_code__max",
+		"fault_rows", "Faults",
+	}
+	if headers := spec.headers(true); !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("headers() = %v, expected %v", headers, expectedHeaders)
+	}
+
+	query := spec.selectQuery(ExportQuery{Table: "t", Order: "asc"})
+	for _, part := range []string{"AVG(`LP_value`)", "SUM(COALESCE(`door_open` = 1, 0))", "GROUP BY bucket ORDER BY bucket ASC"} {
+		if !strings.Contains(query, part) {
+			t.Errorf("selectQuery() = %q, expected it to contain %q", query, part)
+		}
+	}
+
+	// Day buckets of an IST table shown in UTC start at UTC midnight, 05:30 IST
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	day := &AggregateSpec{Interval: AGGREGATE_INTERVALS["1d"]}
+	shifted := "DATE_ADD(created_at, INTERVAL -19800 SECOND)"
+	expectedBucket := "DATE_ADD(DATE(" + shifted + "), INTERVAL FLOOR(TIME_TO_SEC(" + shifted + ") / 86400) * 86400 SECOND)"
+	if result := day.bucketExpr(exportZones{ist, time.UTC}); result != expectedBucket {
+		t.Errorf("bucketExpr(IST, UTC) = %q, expected %q", result, expectedBucket)
+	}
+
+	if _, err := buildAggregateSpec("5m", tableColumns, nil, nil); err == nil {
+		t.Errorf("buildAggregateSpec(5m) returned no error")
+	}
+}
+
+func TestHeaderLabelAggregate(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"LP_value__avg", "LP Value (avg)"},
+		{"Running_hours_min", "Total Running Minutes"},
+		{"custom__max", "custom (max)"},
+	}
+
+	for _, test := range tests {
+		result := headerLabel(test.key, DEFAULT_PROFILE)
+		if result != test.expected {
+			t.Errorf("headerLabel(%s) = %q, expected %q", test.key, result, test.expected)
+		}
+	}
+}
+
+func TestWriteAggregateExportParquetTypes(t *testing.T) {
+	table := &fakeTable{
+		columns: []string{"bucket", "row_count", "MIN(`LP_value`)", "AVG(`LP_value`)", "MAX(`LP_value`)", "SUM(door_open)", "SUM(any)"},
+		types:   []string{"DATETIME", "BIGINT", "DECIMAL", "DECIMAL", "DECIMAL", "DECIMAL", "DECIMAL"},
+		rows: [][]driver.Value{
+			{time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC), int64(4), []byte("3.10"), []byte("3.50"), []byte("3.90"), []byte("1"), []byte("1")},
+		},
+	}
+	database := sql.OpenDB(table)
+	defer database.Close()
+
+	tx, err := database.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var buf bytes.Buffer
+	w, _ := EXPORT_FORMATS["parquet"].NewWriter(&buf, nil)
+	q := ExportQuery{
+		Table: "GTPL_110",
+		Zones: exportZones{Storage: time.UTC, Display: time.UTC},
+		Aggregate: &AggregateSpec{
+			Interval:     3600,
+			Metrics:      []TableColumn{{Name: "LP_value", DataType: "decimal"}},
+			FaultColumns: []TableColumn{{Name: "door_open", DataType: "tinyint"}},
+		},
+	}
+	if _, err := writeAggregateExport(context.Background(), w, tx, q); err != nil {
+		t.Fatalf("writeAggregateExport() error: %v", err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	expected := map[string]parquet.Kind{
+		"created_at":    parquet.Int64,
+		"row_count":     parquet.Int64,
+		"LP_value__min": parquet.Double,
+		"LP_value__avg": parquet.Double,
+		"LP_value__max": parquet.Double,
+		"fault_rows":    parquet.Int64,
+		"Faults":        parquet.ByteArray,
+	}
+	for _, field := range f.Schema().Fields() {
+		kind, ok := expected[field.Name()]
+		if !ok {
+			t.Errorf("unexpected column %s", field.Name())
+			continue
+		}
+		if field.Type().Kind() != kind {
+			t.Errorf("%s kind = %v, expected %v", field.Name(), field.Type().Kind(), kind)
+		}
+		if logical := field.Type().LogicalType(); field.Name() == "created_at" && (logical == nil || logical.Timestamp == nil) {
+			t.Errorf("created_at logical type = %v, expected a timestamp", logical)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Returned by resolveColumnKey when no column matches a name
+var errUnknownColumn = errors.New("unknown column")
+
+// Keys derived from created_at in pretty mode
+var CREATED_AT_DERIVED = []string{"created_at_date", "created_at_time"}
+
+// ColumnSelection restricts an export to a subset of columns. A nil selection
+// exports everything.
+type ColumnSelection struct {
+	// Columns to SELECT, in table order; always includes id for paging
+	Select []string
+	// Keys allowed in the output headers
+	Output map[string]bool
+}
+
+// Get the SELECT list for a selection
+func (s *ColumnSelection) selectClause() string {
+	if s == nil || len(s.Select) == 0 {
+		return "*"
+	}
+
+	quoted := make([]string, len(s.Select))
+	for i, column := range s.Select {
+		quoted[i] = "`" + column + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Read a column without adding it to the output
+func (s *ColumnSelection) fetch(column string) {
+	if s != nil && !containsString(s.Select, column) {
+		s.Select = append(s.Select, column)
+	}
+}
+
+// Drop headers that are not part of the selection
+func (s *ColumnSelection) filterHeaders(headers []string) []string {
+	if s == nil {
+		return headers
+	}
+
+	filtered := make([]string, 0, len(headers))
+	for _, header := range headers {
+		if s.Output[header] {
+			filtered = append(filtered, header)
+		}
+	}
+	return filtered
+}
+
+// TableColumn describes a table column from INFORMATION_SCHEMA
+type TableColumn struct {
+	Name       string
+	DataType   string // e.g. "decimal"
+	ColumnType string // e.g. "decimal(10,2)"
+	Nullable   bool
+}
+
+// Numeric MySQL data types
+var NUMERIC_DATA_TYPES = map[string]bool{
+	"tinyint": true, "smallint": true, "mediumint": true, "int": true, "bigint": true,
+	"decimal": true, "float": true, "double": true,
+}
+
+// Check if a column holds numbers
+func (tc TableColumn) isNumeric() bool {
+	return NUMERIC_DATA_TYPES[strings.ToLower(tc.DataType)]
+}
+
+// Get the columns of a table in ordinal order
+func getTableColumns(ctx context.Context, table string) ([]TableColumn, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []TableColumn
+	for rows.Next() {
+		var column TableColumn
+		var nullable string
+		if err := rows.Scan(&column.Name, &column.DataType, &column.ColumnType, &nullable); err != nil {
+			return nil, err
+		}
+		column.Nullable = nullable == "YES"
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// Get the names of a set of table columns
+func tableColumnNames(columns []TableColumn) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// Build a column selection from columns= and exclude= values. Names may be
+// raw column names or profile labels. When columns= is given, id and
+// created_at (with its pretty date/time split) are kept as row identity
+// unless excluded explicitly. In pretty mode the profile's hidden columns
+// are dropped unless named in columns=.
+func buildColumnSelection(tableColumns, include, exclude []string, pretty bool, profile *HeaderProfile) (*ColumnSelection, error) {
+	hidesColumns := pretty && profile != nil && len(profile.Hidden) > 0
+	if len(include) == 0 && len(exclude) == 0 && !hidesColumns {
+		return nil, nil
+	}
+
+	// Keys a caller may name: real columns plus pretty-mode derived keys
+	available := make(map[string]bool)
+	for _, column := range tableColumns {
+		available[column] = true
+	}
+	if pretty {
+		for _, key := range CREATED_AT_DERIVED {
+			available[key] = true
+		}
+		available["Faults"] = true
+		if available["Fault_code"] {
+			for _, key := range FAULT_CODE_DERIVED {
+				available[key] = true
+			}
+		}
+	}
+
+	output := make(map[string]bool)
+	requested := make(map[string]bool)
+	if len(include) > 0 {
+		for _, key := range []string{"id", "created_at"} {
+			output[key] = true
+		}
+		if pretty {
+			for _, key := range CREATED_AT_DERIVED {
+				output[key] = true
+			}
+		}
+		for _, name := range include {
+			key, err := resolveColumnKey(name, available, profile)
+			if err == errUnknownColumn {
+				return nil, &RequestError{Message: "Unknown column", Details: name}
+			}
+			if err != nil {
+				return nil, err
+			}
+			output[key] = true
+			requested[key] = true
+
+			// Fault code descriptions travel with the code
+			if pretty && key == "Fault_code" {
+				for _, derived := range FAULT_CODE_DERIVED {
+					output[derived] = true
+				}
+			}
+		}
+	} else {
+		for key := range available {
+			output[key] = true
+		}
+	}
+
+	for _, name := range exclude {
+		key, err := resolveColumnKey(name, available, profile)
+		if err == errUnknownColumn {
+			return nil, &RequestError{Message: "Unknown column", Details: name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		delete(output, key)
+	}
+
+	if hidesColumns {
+		for key := range output {
+			if profile.hides(key) && !requested[key] {
+				delete(output, key)
+			}
+		}
+	}
+
+	// id drives keyset paging and created_at feeds the pretty columns, so both
+	// are fetched even when they are not exported
+	var selected []string
+	for _, column := range tableColumns {
+		needed := output[column] || column == "id" || (pretty && column == "created_at")
+		if pretty && output["Faults"] && looksLikeFaultKey(column) {
+			needed = true
+		}
+		if needed {
+			selected = append(selected, column)
+		}
+	}
+
+	return &ColumnSelection{Select: selected, Output: output}, nil
+}
+
+// Resolve a column name or pretty label to a column key
+// Returns errUnknownColumn when nothing matches, and a RequestError when a
+// name matches several keys in a case-insensitive or label lookup
+func resolveColumnKey(name string, available map[string]bool, profile *HeaderProfile) (string, error) {
+	if available[name] {
+		return name, nil
+	}
+
+	keys := make([]string, 0, len(available))
+	for key := range available {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Several keys can share a label (e.g. AHT_vale_speed and AHT_valve_speed),
+	// so only keys present in this table are considered
+	if profile == nil {
+		profile = DEFAULT_PROFILE
+	}
+	for _, matches := range []func(key string) bool{
+		func(key string) bool { return strings.EqualFold(key, name) },
+		func(key string) bool { return strings.EqualFold(profile.label(key), name) },
+	} {
+		var found []string
+		for _, key := range keys {
+			if matches(key) {
+				found = append(found, key)
+			}
+		}
+		switch len(found) {
+		case 0:
+			continue
+		case 1:
+			return found[0], nil
+		default:
+			return "", &RequestError{Message: "Ambiguous column", Details: name + " matches " + strings.Join(found, ", ")}
+		}
+	}
+
+	return "", errUnknownColumn
+}
+
+// Split a comma separated parameter into trimmed, non-empty values
+func splitList(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildColumnSelection(t *testing.T) {
+	tableColumns := []string{"id", "created_at", "T1_temp_mean", "LP_value", "AHT_vale_speed", "door_open"}
+
+	tests := []struct {
+		include        []string
+		exclude        []string
+		pretty         bool
+		expectedSelect []string
+		expectedOutput []string
+	}{
+		{
+			include:        []string{"T1 Mean Temp (°C)", "Faults"},
+			pretty:         true,
+			expectedSelect: []string{"id", "created_at", "T1_temp_mean", "door_open"},
+			expectedOutput: []string{"id", "created_at", "created_at_date", "created_at_time", "T1_temp_mean", "Faults"},
+		},
+		{
+			include:        []string{"lp_value", "AHT Valve Speed (%)"},
+			exclude:        []string{"created_at"},
+			expectedSelect: []string{"id", "LP_value", "AHT_vale_speed"},
+			expectedOutput: []string{"id", "LP_value", "AHT_vale_speed"},
+		},
+		{
+			exclude:        []string{"door_open", "LP_value"},
+			expectedSelect: []string{"id", "created_at", "T1_temp_mean", "AHT_vale_speed"},
+			expectedOutput: []string{"id", "created_at", "T1_temp_mean", "AHT_vale_speed"},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := buildColumnSelection(tableColumns, test.include, test.exclude, test.pretty, nil)
+		if err != nil {
+			t.Fatalf("buildColumnSelection(%v, %v) error: %v", test.include, test.exclude, err)
+		}
+		if !reflect.DeepEqual(result.Select, test.expectedSelect) {
+			t.Errorf("buildColumnSelection(%v, %v) select = %v, expected %v", test.include, test.exclude, result.Select, test.expectedSelect)
+		}
+		headers := append(append([]string{}, tableColumns...), "created_at_date", "created_at_time", "Faults")
+		expected := make(map[string]bool)
+		for _, key := range test.expectedOutput {
+			expected[key] = true
+		}
+		for _, header := range headers {
+			if result.Output[header] != expected[header] {
+				t.Errorf("buildColumnSelection(%v, %v) output[%s] = %v, expected %v", test.include, test.exclude, header, result.Output[header], expected[header])
+			}
+		}
+	}
+
+	if _, err := buildColumnSelection(tableColumns, []string{"missing"}, nil, true, nil); err == nil {
+		t.Errorf("buildColumnSelection() with unknown column returned no error")
+	}
+	if _, err := buildColumnSelection(tableColumns, []string{"Faults"}, nil, false, nil); err == nil {
+		t.Errorf("buildColumnSelection() with Faults in raw mode returned no error")
+	}
+}
+
+func TestColumnSelectionSelectClause(t *testing.T) {
+	var all *ColumnSelection
+	if result := all.selectClause(); result != "*" {
+		t.Errorf("nil selectClause() = %q, expected \"*\"", result)
+	}
+
+	s := &ColumnSelection{Select: []string{"id", "LP_value"}}
+	if result := s.selectClause(); result != "`id`, `LP_value`" {
+		t.Errorf("selectClause() = %q, expected \"`id`, `LP_value`\"", result)
+	}
+}
+
+func TestResolveColumnKey(t *testing.T) {
+	available := map[string]bool{"id": true, "LP_value": true, "AHT_vale_speed": true, "AHT_valve_speed": true, "Door_open": true, "door_open": true}
+
+	tests := []struct {
+		name          string
+		expected      string
+		expectedError string
+	}{
+		{"LP_value", "LP_value", ""},
+		{"lp_value", "LP_value", ""},
+		{"LP Value", "LP_value", ""},
+		{"door_open", "door_open", ""},
+		{"DOOR_OPEN", "", "Ambiguous column"},
+		{"AHT Valve Speed (%)", "", "Ambiguous column"},
+		{"missing", "", errUnknownColumn.Error()},
+	}
+
+	for _, test := range tests {
+		// Repeated lookups must not depend on map order
+		for i := 0; i < 10; i++ {
+			result, err := resolveColumnKey(test.name, available, nil)
+			message := ""
+			if reqErr, ok := err.(*RequestError); ok {
+				message = reqErr.Message
+			} else if err != nil {
+				message = err.Error()
+			}
+			if result != test.expected || message != test.expectedError {
+				t.Errorf("resolveColumnKey(%q) = %q, %v, expected %q, %q", test.name, result, err, test.expected, test.expectedError)
+				break
+			}
+		}
+	}
+}
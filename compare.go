@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Most machines in one comparison; each holds a DB connection while streaming
+const MAX_COMPARE_TABLES = 8
+
+// Differences above this share of the bucket mean are highlighted by default
+const DEFAULT_COMPARE_THRESHOLD = "5%"
+
+// Formats a comparison can be written as
+var COMPARE_FORMATS = []string{"xlsx", "csv", "tsv"}
+
+// CompareRequest represents the compare request parameters. Range, filter,
+// tz, profile, headers and format work as for exports.
+type CompareRequest struct {
+	ExportRequest
+	Interval  string `form:"interval"`
+	Threshold string `form:"threshold"`
+}
+
+// compareThreshold decides when the machines of a bucket differ
+type compareThreshold struct {
+	Value    float64
+	Relative bool // Value is a percentage of the mean
+}
+
+// Parse threshold= as an absolute spread ("2.5") or a share of the mean ("5%")
+func parseCompareThreshold(value string) (compareThreshold, error) {
+	if value == "" {
+		value = DEFAULT_COMPARE_THRESHOLD
+	}
+
+	number, relative := strings.CutSuffix(strings.TrimSpace(value), "%")
+	f, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return compareThreshold{}, &RequestError{Message: "Invalid threshold", Details: "Expected a non-negative number or percentage such as 2.5 or 5%, got " + value}
+	}
+	return compareThreshold{Value: f, Relative: relative}, nil
+}
+
+// Get the spread (max - min) of the values and whether it exceeds the threshold
+func (t compareThreshold) compare(values []float64) (float64, bool) {
+	if len(values) < 2 {
+		return 0, false
+	}
+
+	min, max, sum := values[0], values[0], 0.0
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		sum += v
+	}
+
+	// Round away float noise such as 0.30000000000000004
+	spread := math.Round((max-min)*1e6) / 1e6
+	limit := t.Value
+	if t.Relative {
+		limit = math.Abs(sum/float64(len(values))) * t.Value / 100
+	}
+	return spread, spread > limit
+}
+
+// CompareSpec describes a comparison of several machines on shared time buckets
+type CompareSpec struct {
+	Interval  int           // bucket size in seconds
+	Metrics   []string      // metric keys, named after the first machine's columns
+	Queries   []ExportQuery // per machine: table, conditions and zones
+	Columns   [][]string    // per machine, the column holding each metric
+	Threshold compareThreshold
+	Labels    *HeaderProfile // header labels, nil for raw keys
+}
+
+// Key of a metric's value for one machine
+func compareKey(metric, table string) string {
+	return metric + "@" + table
+}
+
+// Build the bucket query for one machine; buckets come back in time order
+func (s *CompareSpec) selectQuery(i int) string {
+	q := s.Queries[i]
+	bucket := &AggregateSpec{Interval: s.Interval}
+
+	fields := []string{bucket.bucketExpr(q.Zones) + " AS bucket"}
+	for _, column := range s.Columns[i] {
+		fields = append(fields, fmt.Sprintf("AVG(`%s`)", column))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM `%s`%s GROUP BY bucket ORDER BY bucket ASC",
+		strings.Join(fields, ", "), q.Table, q.WhereClause)
+}
+
+// Get output headers: each metric per machine followed by its spread
+func (s *CompareSpec) headers() []string {
+	headers := []string{"created_at"}
+	for _, metric := range s.Metrics {
+		for _, q := range s.Queries {
+			headers = append(headers, compareKey(metric, q.Table))
+		}
+		headers = append(headers, aggregateKey(metric, "spread"))
+	}
+	return append(headers, "Differences")
+}
+
+// Get header labels naming the machine behind each value
+func (s *CompareSpec) labels(profile *HeaderProfile) *HeaderProfile {
+	labels := make(map[string]string)
+	for _, metric := range s.Metrics {
+		for _, q := range s.Queries {
+			name := q.Table
+			if machine, ok := registry.lookup(q.Table); ok && machine.Name != "" {
+				name = machine.Name
+			}
+			labels[compareKey(metric, q.Table)] = profile.label(metric) + " - " + name
+		}
+	}
+	return profile.withLabels(labels)
+}
+
+// Build the row of one bucket. values holds each machine's averages in
+// metric order, or nil when the machine has no rows in the bucket. Metrics
+// whose spread exceeds the threshold are highlighted and listed under
+// Differences.
+func (s *CompareSpec) row(at time.Time, values [][]interface{}) DataRow {
+	row := DataRow{"created_at": at.Format("2006-01-02 15:04:05")}
+
+	var differs []string
+	for m, metric := range s.Metrics {
+		var present []float64
+		for i, q := range s.Queries {
+			if values[i] == nil {
+				continue
+			}
+			value := toNum(aggregateValue(values[i][m]))
+			row[compareKey(metric, q.Table)] = value
+			if f, ok := value.(float64); ok {
+				present = append(present, f)
+			}
+		}
+		if len(present) < 2 {
+			continue
+		}
+
+		spread, differ := s.Threshold.compare(present)
+		row[aggregateKey(metric, "spread")] = spread
+		if !differ {
+			continue
+		}
+
+		differs = append(differs, strings.ReplaceAll(metric, "_", " "))
+		row[aggregateKey(metric, "spread")] = highlighted{Value: spread}
+		for _, q := range s.Queries {
+			key := compareKey(metric, q.Table)
+			if value, ok := row[key]; ok {
+				row[key] = highlighted{Value: value}
+			}
+		}
+	}
+
+	row["Differences"] = strings.Join(differs, ", ")
+	return row
+}
+
+// compareCursor walks one machine's buckets in time order
+type compareCursor struct {
+	rows      *sql.Rows
+	zones     exportZones
+	values    []interface{}
+	valuePtrs []interface{}
+	at        time.Time
+	done      bool
+}
+
+// Move to the next bucket
+func (c *compareCursor) next() error {
+	if !c.rows.Next() {
+		c.done = true
+		return c.rows.Err()
+	}
+
+	if err := c.rows.Scan(c.valuePtrs...); err != nil {
+		return err
+	}
+	at, ok := c.zones.createdAtTime(c.values[0])
+	if !ok {
+		return fmt.Errorf("unexpected bucket value %v", c.values[0])
+	}
+	c.at = at
+	return nil
+}
+
+// Stream one row per bucket through a RowWriter. Each machine's buckets are
+// read by its own query and merged on the bucket start, so nothing is
+// buffered beyond the current bucket.
+func writeCompare(ctx context.Context, w RowWriter, s *CompareSpec) (int, error) {
+	defer w.Close()
+
+	cursors := make([]*compareCursor, len(s.Queries))
+	for i, q := range s.Queries {
+		rows, err := db.QueryContext(ctx, s.selectQuery(i), q.Params...)
+		if err != nil {
+			return 0, fmt.Errorf("error querying buckets of %s: %v", q.Table, err)
+		}
+		defer rows.Close()
+
+		cursor := &compareCursor{rows: rows, zones: q.Zones.displayed(), values: make([]interface{}, 1+len(s.Metrics))}
+		cursor.valuePtrs = make([]interface{}, len(cursor.values))
+		for j := range cursor.values {
+			cursor.valuePtrs[j] = &cursor.values[j]
+		}
+		if err := cursor.next(); err != nil {
+			return 0, fmt.Errorf("error reading buckets of %s: %v", q.Table, err)
+		}
+		cursors[i] = cursor
+	}
+
+	if err := w.WriteHeader(s.headers()); err != nil {
+		return 0, err
+	}
+
+	rowCount := 0
+	values := make([][]interface{}, len(cursors))
+	for {
+		if err := ctx.Err(); err != nil {
+			return rowCount, err
+		}
+
+		// The next bucket is the earliest one any machine has left
+		var at time.Time
+		found := false
+		for _, cursor := range cursors {
+			if !cursor.done && (!found || cursor.at.Before(at)) {
+				at, found = cursor.at, true
+			}
+		}
+		if !found {
+			break
+		}
+
+		for i, cursor := range cursors {
+			values[i] = nil
+			if !cursor.done && cursor.at.Equal(at) {
+				values[i] = cursor.values[1:]
+			}
+		}
+		if err := w.WriteRow(s.row(at, values)); err != nil {
+			return rowCount, err
+		}
+		rowCount++
+
+		for i, cursor := range cursors {
+			if values[i] == nil {
+				continue
+			}
+			if err := cursor.next(); err != nil {
+				return rowCount, fmt.Errorf("error reading buckets of %s: %v", s.Queries[i].Table, err)
+			}
+		}
+	}
+
+	return rowCount, w.Flush()
+}
+
+// Validate a compare request and resolve it into the format and the
+// per-machine queries to run
+func prepareCompare(ctx context.Context, req CompareRequest) (ExportFormat, *CompareSpec, error) {
+	tables, err := resolveExportTables(req.ExportRequest)
+	if err != nil {
+		return ExportFormat{}, nil, err
+	}
+	if len(tables) < 2 {
+		return ExportFormat{}, nil, &RequestError{Message: "Compare needs at least two machines", Details: "Repeat table= or use site="}
+	}
+	if len(tables) > MAX_COMPARE_TABLES {
+		return ExportFormat{}, nil, &RequestError{Message: "Too many machines", Details: fmt.Sprintf("At most %d machines can be compared", MAX_COMPARE_TABLES)}
+	}
+
+	if req.Format == "" {
+		req.Format = "xlsx"
+	}
+	format, ok := EXPORT_FORMATS[strings.ToLower(req.Format)]
+	if !ok || !containsString(COMPARE_FORMATS, format.Extension) {
+		return ExportFormat{}, nil, &RequestError{Message: "Invalid format", Details: "Supported formats: " + strings.Join(COMPARE_FORMATS, ", ")}
+	}
+
+	if req.Interval == "" {
+		req.Interval = "15m"
+	}
+	spec := &CompareSpec{}
+	spec.Interval, err = aggregateInterval(req.Interval)
+	if err != nil {
+		return format, nil, err
+	}
+	spec.Threshold, err = parseCompareThreshold(req.Threshold)
+	if err != nil {
+		return format, nil, err
+	}
+
+	names := splitList(req.Columns)
+	if len(names) == 0 {
+		return format, nil, &RequestError{Message: "Missing columns", Details: "Name the numeric columns to compare, e.g. columns=T1_temp_mean,HP_value"}
+	}
+
+	// Buckets of every machine are shown in the first machine's display zone
+	display, err := resolveZones(req.TZ, tables[0])
+	if err != nil {
+		return format, nil, err
+	}
+	bounds, err := parseTimeRange(req.ExportRequest, display, time.Now())
+	if err != nil {
+		return format, nil, err
+	}
+
+	var labels *HeaderProfile
+	for i, table := range tables {
+		query, columns, profile, err := prepareCompareTable(ctx, req, table, names, bounds, display.Display)
+		if err != nil {
+			if reqErr, ok := err.(*RequestError); ok {
+				return format, nil, &RequestError{Message: reqErr.Message, Details: table + ": " + reqErr.Details}
+			}
+			return format, nil, err
+		}
+		spec.Queries = append(spec.Queries, query)
+		spec.Columns = append(spec.Columns, columns)
+
+		// Labels follow the first machine's profile
+		if i == 0 {
+			labels = profile
+		}
+	}
+	spec.Metrics = spec.Columns[0]
+	if req.Headers != "raw" {
+		spec.Labels = spec.labels(labels.withUnit("created_at", display.label()))
+	}
+
+	return format, spec, nil
+}
+
+// Resolve one machine of a comparison: its conditions, zones and the column
+// holding each requested metric. Names resolve per table, so a label matches
+// AHT_vale_speed on one machine and AHT_valve_speed on another.
+func prepareCompareTable(ctx context.Context, req CompareRequest, table string, names []string, bounds timeRange, display *time.Location) (ExportQuery, []string, *HeaderProfile, error) {
+	zones, err := resolveZones(req.TZ, table)
+	if err != nil {
+		return ExportQuery{}, nil, nil, err
+	}
+	zones.Display = display
+	if err := zones.checkConvertTZ(ctx, db); err != nil {
+		return ExportQuery{}, nil, nil, err
+	}
+	whereClause, params := buildWhereClause(bounds, zones)
+
+	profile, err := resolveProfile(req.Profile, table)
+	if err != nil {
+		return ExportQuery{}, nil, nil, err
+	}
+
+	tableColumns, err := getTableColumns(ctx, table)
+	if err != nil {
+		return ExportQuery{}, nil, nil, fmt.Errorf("error reading table columns: %v", err)
+	}
+
+	if len(req.Filters) > 0 {
+		conditions, err := parseFilter(req.Filters, tableColumns)
+		if err != nil {
+			return ExportQuery{}, nil, nil, err
+		}
+		whereClause, params = applyFilter(whereClause, params, conditions)
+	}
+
+	numeric := make(map[string]bool)
+	for _, column := range tableColumns {
+		if column.isNumeric() && column.Name != "id" {
+			numeric[column.Name] = true
+		}
+	}
+	columns := make([]string, len(names))
+	for i, name := range names {
+		column, err := resolveColumnKey(name, numeric, profile)
+		if err == errUnknownColumn {
+			return ExportQuery{}, nil, nil, &RequestError{Message: "Unknown or non-numeric column", Details: name}
+		}
+		if err != nil {
+			return ExportQuery{}, nil, nil, err
+		}
+		columns[i] = column
+	}
+
+	query := ExportQuery{Table: table, WhereClause: whereClause, Params: params, Zones: zones, Profile: profile}
+	return query, columns, profile, nil
+}
+
+// Check if a list contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle side-by-side machine comparison request
+func handleCompare(c *gin.Context) {
+	var req CompareRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid request parameters"})
+		return
+	}
+
+	// Stop querying as soon as the client goes away
+	ctx := c.Request.Context()
+
+	format, spec, err := prepareCompare(ctx, req)
+	if err != nil {
+		if _, ok := err.(*RequestError); ok {
+			respondRequestError(c, err)
+			return
+		}
+		log.Printf("Error preparing comparison: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to read table columns"})
+		return
+	}
+
+	filename := fmt.Sprintf("compare_%d_machines_%s.%s", len(spec.Queries), time.Now().Format("2006-01-02"), format.Extension)
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Access-Control-Expose-Headers", "Content-Disposition")
+
+	writer, err := format.NewWriter(c.Writer, spec.Labels)
+	if err != nil {
+		log.Printf("Error creating %s writer: %v", format.Extension, err)
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to create export file"})
+		return
+	}
+
+	rowCount, err := writeCompare(ctx, writer, spec)
+	if ctx.Err() != nil {
+		log.Printf("Comparison canceled by client after %d buckets", rowCount)
+		return
+	}
+	if err != nil {
+		log.Printf("Error streaming comparison after %d buckets: %v", rowCount, err)
+		// Once the body has started we can only abort the transfer
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to process data"})
+		}
+		return
+	}
+
+	log.Printf("Compared %d machines over %d buckets", len(spec.Queries), rowCount)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestParseCompareThreshold(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected compareThreshold
+	}{
+		{"", compareThreshold{Value: 5, Relative: true}},
+		{"2.5", compareThreshold{Value: 2.5}},
+		{"10%", compareThreshold{Value: 10, Relative: true}},
+		{"0", compareThreshold{Value: 0}},
+	}
+
+	for _, test := range tests {
+		result, err := parseCompareThreshold(test.value)
+		if err != nil || result != test.expected {
+			t.Errorf("parseCompareThreshold(%q) = %+v, %v, expected %+v", test.value, result, err, test.expected)
+		}
+	}
+
+	for _, value := range []string{"abc", "-1", "%", "Inf"} {
+		if _, err := parseCompareThreshold(value); err == nil {
+			t.Errorf("parseCompareThreshold(%q) returned no error", value)
+		}
+	}
+}
+
+func TestCompareThreshold(t *testing.T) {
+	tests := []struct {
+		threshold      compareThreshold
+		values         []float64
+		expectedSpread float64
+		expectedDiffer bool
+	}{
+		{compareThreshold{Value: 1}, []float64{10, 10.5}, 0.5, false},
+		{compareThreshold{Value: 1}, []float64{10, 12, 11}, 2, true},
+		{compareThreshold{Value: 5, Relative: true}, []float64{100, 104}, 4, false},
+		{compareThreshold{Value: 5, Relative: true}, []float64{100, 110}, 10, true},
+		{compareThreshold{Value: 0}, []float64{0.1, 0.4}, 0.3, true},
+		{compareThreshold{Value: 0}, []float64{7}, 0, false},
+	}
+
+	for _, test := range tests {
+		spread, differ := test.threshold.compare(test.values)
+		if spread != test.expectedSpread || differ != test.expectedDiffer {
+			t.Errorf("compare(%v) with %+v = %v, %v, expected %v, %v", test.values, test.threshold, spread, differ, test.expectedSpread, test.expectedDiffer)
+		}
+	}
+}
+
+func TestCompareSpecRow(t *testing.T) {
+	spec := &CompareSpec{
+		Metrics:   []string{"T1_temp_mean", "HP_value"},
+		Queries:   []ExportQuery{{Table: "GTPL_110"}, {Table: "GTPL_111"}, {Table: "GTPL_112"}},
+		Threshold: compareThreshold{Value: 1},
+	}
+	at := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+
+	row := spec.row(at, [][]interface{}{
+		{[]byte("20.5"), []byte("14")},
+		nil,
+		{[]byte("23.0"), []byte("14.5")},
+	})
+
+	expected := DataRow{
+		"created_at":            "2026-03-01 10:15:00",
+		"T1_temp_mean@GTPL_110": highlighted{Value: 20.5},
+		"T1_temp_mean@GTPL_112": highlighted{Value: 23.0},
+		"T1_temp_mean__spread":  highlighted{Value: 2.5},
+		"HP_value@GTPL_110":     14.0,
+		"HP_value@GTPL_112":     14.5,
+		"HP_value__spread":      0.5,
+		"Differences":           "T1 temp mean",
+	}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("row() = %v, expected %v", row, expected)
+	}
+
+	// A bucket only one machine has data for has nothing to compare
+	row = spec.row(at, [][]interface{}{nil, {[]byte("21"), nil}, nil})
+	if _, ok := row["T1_temp_mean__spread"]; ok || row["Differences"] != "" {
+		t.Errorf("row() with one machine = %v, expected no spread", row)
+	}
+}
+
+func TestCompareSpecHeaders(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_110", Name: "GTPL 110"}})
+	defer registry.set(nil)
+
+	spec := &CompareSpec{
+		Metrics: []string{"HP_value"},
+		Queries: []ExportQuery{{Table: "GTPL_110"}, {Table: "GTPL_111"}},
+	}
+
+	headers := spec.headers()
+	expected := []string{"created_at", "HP_value@GTPL_110", "HP_value@GTPL_111", "HP_value__spread", "Differences"}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("headers() = %v, expected %v", headers, expected)
+	}
+
+	labels := spec.labels(DEFAULT_PROFILE)
+	expectedLabels := []string{"Date & Time", "HP Value - GTPL 110", "HP Value - GTPL_111", "HP Value (spread)", "Differences"}
+	for i, header := range headers {
+		if result := headerLabel(header, labels); result != expectedLabels[i] {
+			t.Errorf("headerLabel(%q) = %q, expected %q", header, result, expectedLabels[i])
+		}
+	}
+}
+
+func TestCompareRequestBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/compare?table=A&table=B&interval=1h&columns=HP_value&threshold=2", nil)
+
+	var req CompareRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		t.Fatalf("ShouldBindQuery() error: %v", err)
+	}
+	if !reflect.DeepEqual(req.Tables, []string{"A", "B"}) || req.Interval != "1h" || req.Columns != "HP_value" || req.Threshold != "2" {
+		t.Errorf("ShouldBindQuery() = %+v", req)
+	}
+}
+
+func TestXLSXWriterHighlighted(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, nil)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteHeader([]string{"a", "b"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := w.WriteRow(DataRow{"a": 1.5, "b": highlighted{Value: 2.5}}); err != nil {
+		t.Fatalf("WriteRow() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	if value, _ := f.GetCellValue(DATA_SHEET, "B2"); value != "2.5" {
+		t.Errorf("cell B2 = %q, expected %q", value, "2.5")
+	}
+	plain, _ := f.GetCellStyle(DATA_SHEET, "A2")
+	styled, _ := f.GetCellStyle(DATA_SHEET, "B2")
+	if styled == 0 || styled == plain {
+		t.Errorf("cell B2 style = %d, expected a highlight style", styled)
+	}
+	if result := formatTextValue(highlighted{Value: 2.5}); result != "2.5" {
+		t.Errorf("formatTextValue(highlighted) = %q, expected %q", result, "2.5")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sheet name for the fault episode report
+const EPISODES_SHEET = "Fault Episodes"
+
+// Most row ids listed per episode by /faults/episodes
+const MAX_EPISODE_IDS = 1000
+
+// FaultEpisode is a contiguous run of rows in which a fault flag stayed
+// active. It ends when the first row without the fault was recorded; an
+// episode still active at the end of the range is ongoing and ends at its
+// last row. IDs lists at most MAX_EPISODE_IDS of its row ids, and only for
+// /faults/episodes.
+type FaultEpisode struct {
+	Fault           string        `json:"fault"`
+	Label           string        `json:"label"`
+	Start           time.Time     `json:"start"`
+	End             time.Time     `json:"end"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Ongoing         bool          `json:"ongoing"`
+	FirstID         interface{}   `json:"firstId"`
+	LastID          interface{}   `json:"lastId"`
+	IDs             []interface{} `json:"ids"`
+	IDsTruncated    bool          `json:"idsTruncated"`
+	Rows            int           `json:"rows"`
+}
+
+// queryer runs queries on either the pool or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// faultEpisodeTracker turns a created_at ordered stream of rows into episodes
+type faultEpisodeTracker struct {
+	faultKeys []string
+	maxIDs    int // row ids kept per episode, 0 for none
+	open      map[string]*FaultEpisode
+	episodes  []FaultEpisode
+}
+
+// Create a tracker for the given fault columns, keeping up to maxIDs row ids
+// per episode
+func newFaultEpisodeTracker(faultKeys []string, maxIDs int) *faultEpisodeTracker {
+	return &faultEpisodeTracker{faultKeys: faultKeys, maxIDs: maxIDs, open: make(map[string]*FaultEpisode)}
+}
+
+// Record one row; rows must arrive in created_at order
+func (t *faultEpisodeTracker) observe(id interface{}, at time.Time, row DataRow) {
+	for _, key := range t.faultKeys {
+		episode, isOpen := t.open[key]
+
+		if !isTrueish(row[key]) {
+			if isOpen {
+				episode.End = at
+				t.close(key, episode)
+			}
+			continue
+		}
+
+		if !isOpen {
+			episode = &FaultEpisode{Fault: key, Label: strings.ReplaceAll(key, "_", " "), Start: at, FirstID: id}
+			t.open[key] = episode
+		}
+		episode.End = at
+		episode.LastID = id
+		if len(episode.IDs) < t.maxIDs {
+			episode.IDs = append(episode.IDs, id)
+		} else if t.maxIDs > 0 {
+			episode.IDsTruncated = true
+		}
+		episode.Rows++
+	}
+}
+
+// Close an open episode
+func (t *faultEpisodeTracker) close(key string, episode *FaultEpisode) {
+	episode.DurationSeconds = episode.End.Sub(episode.Start).Seconds()
+	t.episodes = append(t.episodes, *episode)
+	delete(t.open, key)
+}
+
+// Close any episodes still open and return all episodes ordered by start
+func (t *faultEpisodeTracker) finish() []FaultEpisode {
+	for key, episode := range t.open {
+		episode.Ongoing = true
+		t.close(key, episode)
+	}
+
+	sort.SliceStable(t.episodes, func(i, j int) bool {
+		if t.episodes[i].Start.Equal(t.episodes[j].Start) {
+			return t.episodes[i].Fault < t.episodes[j].Fault
+		}
+		return t.episodes[i].Start.Before(t.episodes[j].Start)
+	})
+	return t.episodes
+}
+
+// Find fault episodes by scanning the fault columns in created_at order. Only
+// id, created_at and the fault flags are fetched, and rows are not buffered
+// beyond maxIDs ids per episode. Episode times are in the display zone.
+func findFaultEpisodes(ctx context.Context, q queryer, table, whereClause string, params []interface{}, zones exportZones, maxIDs int) ([]FaultEpisode, error) {
+	tableColumns, err := getTableColumns(ctx, table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading table columns: %v", err)
+	}
+
+	var faultKeys []string
+	for _, column := range tableColumns {
+		if looksLikeFaultKey(column.Name) {
+			faultKeys = append(faultKeys, column.Name)
+		}
+	}
+	if len(faultKeys) == 0 {
+		return nil, nil
+	}
+
+	selected := &ColumnSelection{Select: append([]string{"id", "created_at"}, faultKeys...)}
+	query := fmt.Sprintf("SELECT %s FROM `%s`%s ORDER BY created_at ASC, id ASC", selected.selectClause(), table, whereClause)
+
+	rows, err := q.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying fault columns: %v", err)
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(selected.Select))
+	valuePtrs := make([]interface{}, len(values))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	tracker := newFaultEpisodeTracker(faultKeys, maxIDs)
+	row := make(DataRow, len(faultKeys))
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, err
+		}
+
+		at, ok := zones.createdAtTime(values[1])
+		if !ok {
+			continue
+		}
+		for i, key := range faultKeys {
+			row[key] = values[i+2]
+		}
+
+		id := values[0]
+		if b, ok := id.([]byte); ok {
+			id = toNum(string(b))
+		}
+		tracker.observe(id, at, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracker.finish(), nil
+}
+
+// Get a created_at value as returned by the driver as a time in the display
+// zone; text values are wall-clock times in the storage zone
+func (z exportZones) createdAtTime(raw interface{}) (time.Time, bool) {
+	if t, ok := z.toDisplay(raw).(time.Time); ok {
+		return t, true
+	}
+
+	if b, ok := raw.([]byte); ok {
+		raw = string(b)
+	}
+	full := normalizeCreatedAt(raw)["full"]
+	storage, display := z.Storage, z.Display
+	if storage == nil {
+		storage = time.UTC
+	}
+	if display == nil {
+		display = storage
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", full, storage)
+	return t.In(display), err == nil
+}
+
+// Write the fault episode report as an extra sheet
+func writeFaultEpisodeSheet(ctx context.Context, sw sheetWriter, tx *sql.Tx, q ExportQuery) error {
+	// The sheet shows the first and last record only
+	episodes, err := findFaultEpisodes(ctx, tx, q.Table, q.WhereClause, q.Params, q.Zones, 0)
+	if err != nil {
+		return err
+	}
+
+	zone := q.Zones.label()
+	headers := []string{"Fault", "Start (" + zone + ")", "End (" + zone + ")", "Duration (s)", "Duration", "Ongoing", "First Record#", "Last Record#", "Rows"}
+	rows := make([][]interface{}, len(episodes))
+	for i, episode := range episodes {
+		rows[i] = []interface{}{
+			episode.Label,
+			episode.Start.Format("2006-01-02 15:04:05"),
+			episode.End.Format("2006-01-02 15:04:05"),
+			episode.DurationSeconds,
+			(time.Duration(episode.DurationSeconds) * time.Second).String(),
+			episode.Ongoing,
+			episode.FirstID,
+			episode.LastID,
+			episode.Rows,
+		}
+	}
+
+	return sw.WriteSheet(EPISODES_SHEET, headers, rows)
+}
+
+// Handle fault episode report request
+func handleFaultEpisodes(c *gin.Context) {
+	var req ExportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid request parameters"})
+		return
+	}
+
+	if req.Table == "" || !isTableAllowed(req.Table) {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid or missing table name"})
+		return
+	}
+
+	zones, err := resolveZones(req.TZ, req.Table)
+	if err != nil {
+		respondRequestError(c, err)
+		return
+	}
+
+	bounds, err := parseTimeRange(req, zones, time.Now())
+	if err != nil {
+		respondRequestError(c, err)
+		return
+	}
+	whereClause, params := buildWhereClause(bounds, zones)
+
+	episodes, err := findFaultEpisodes(c.Request.Context(), db, req.Table, whereClause, params, zones, MAX_EPISODE_IDS)
+	if err != nil {
+		log.Printf("Error finding fault episodes: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to find fault episodes"})
+		return
+	}
+	if episodes == nil {
+		episodes = []FaultEpisode{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table":     req.Table,
+		"timezone":  zones.Display.String(),
+		"episodes":  episodes,
+		"count":     len(episodes),
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
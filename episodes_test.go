@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFaultEpisodeTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	samples := []DataRow{
+		{"door_open": int64(0), "overheat_protection": int64(1)},
+		{"door_open": int64(1), "overheat_protection": int64(1)},
+		{"door_open": int64(1), "overheat_protection": int64(0)},
+		{"door_open": int64(0), "overheat_protection": int64(0)},
+		{"door_open": []byte("1"), "overheat_protection": int64(0)},
+	}
+
+	tracker := newFaultEpisodeTracker([]string{"door_open", "overheat_protection"}, MAX_EPISODE_IDS)
+	for i, row := range samples {
+		tracker.observe(int64(i+1), start.Add(time.Duration(i)*time.Minute), row)
+	}
+	episodes := tracker.finish()
+
+	expected := []struct {
+		fault    string
+		ids      []interface{}
+		duration float64
+		ongoing  bool
+	}{
+		// Episodes end when the first row without the fault was recorded
+		{"overheat_protection", []interface{}{int64(1), int64(2)}, 120, false},
+		{"door_open", []interface{}{int64(2), int64(3)}, 120, false},
+		{"door_open", []interface{}{int64(5)}, 0, true},
+	}
+
+	if len(episodes) != len(expected) {
+		t.Fatalf("got %d episodes, expected %d: %+v", len(episodes), len(expected), episodes)
+	}
+	for i, test := range expected {
+		episode := episodes[i]
+		if episode.Fault != test.fault || !reflect.DeepEqual(episode.IDs, test.ids) || episode.FirstID != test.ids[0] || episode.LastID != test.ids[len(test.ids)-1] ||
+			episode.Rows != len(test.ids) || episode.DurationSeconds != test.duration || episode.Ongoing != test.ongoing {
+			t.Errorf("episode %d = %+v, expected %+v", i, episode, test)
+		}
+	}
+}
+
+func TestFaultEpisodeTrackerMaxIDs(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		maxIDs            int
+		expectedIDs       []interface{}
+		expectedTruncated bool
+	}{
+		{0, nil, false},
+		{2, []interface{}{int64(1), int64(2)}, true},
+		{5, []interface{}{int64(1), int64(2), int64(3)}, false},
+	}
+
+	for _, test := range tests {
+		tracker := newFaultEpisodeTracker([]string{"door_open"}, test.maxIDs)
+		for i := 0; i < 3; i++ {
+			tracker.observe(int64(i+1), start.Add(time.Duration(i)*time.Minute), DataRow{"door_open": int64(1)})
+		}
+		episodes := tracker.finish()
+		if len(episodes) != 1 {
+			t.Fatalf("got %d episodes, expected 1", len(episodes))
+		}
+		episode := episodes[0]
+		if !reflect.DeepEqual(episode.IDs, test.expectedIDs) || episode.IDsTruncated != test.expectedTruncated || episode.Rows != 3 || episode.LastID != int64(3) {
+			t.Errorf("newFaultEpisodeTracker(%d) episode = %+v, expected ids %v, truncated %v", test.maxIDs, episode, test.expectedIDs, test.expectedTruncated)
+		}
+	}
+}
+
+func TestCreatedAtTime(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	zones := exportZones{Storage: ist, Display: time.UTC}
+	expected := time.Date(2024, 7, 1, 4, 30, 0, 0, time.UTC)
+
+	for _, raw := range []interface{}{
+		time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC), // wall clock as read by the driver
+		"2024-07-01 10:00:00",
+		[]byte("2024-07-01T10:00:00"),
+	} {
+		result, ok := zones.createdAtTime(raw)
+		if !ok || !result.Equal(expected) || result.Location() != time.UTC {
+			t.Errorf("createdAtTime(%v) = %v, %v, expected %v", raw, result, ok, expected)
+		}
+	}
+
+	if _, ok := zones.createdAtTime("not a time"); ok {
+		t.Errorf("createdAtTime(\"not a time\") succeeded, expected failure")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Table families sharing a PLC program and therefore a fault code table
+const (
+	FAMILY_S7_200  = "S7_200"
+	FAMILY_S7_1200 = "S7_1200"
+	FAMILY_GT1000T = "GT1000T"
+)
+
+// Columns added next to Fault_code in pretty exports
+var FAULT_CODE_DERIVED = []string{"Fault_description", "Fault_severity", "Fault_action"}
+
+// FaultCode describes one Fault_code value
+type FaultCode struct {
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	Action      string `json:"action"`
+}
+
+// Fault code dictionaries keyed by family, then by code
+var FAULT_CODES = map[string]map[string]FaultCode{}
+
+// Load the fault code dictionary from FAULT_CODES_FILE
+func loadFaultCodes() {
+	path := os.Getenv("FAULT_CODES_FILE")
+	if path == "" {
+		path = "config/fault_codes.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Fault code dictionary not loaded: %v", err)
+		return
+	}
+
+	codes := map[string]map[string]FaultCode{}
+	if err := json.Unmarshal(data, &codes); err != nil {
+		log.Printf("Fault code dictionary %s is invalid: %v", path, err)
+		return
+	}
+
+	FAULT_CODES = codes
+	log.Printf("Loaded fault codes for %d table families from %s", len(codes), path)
+	for family, familyCodes := range codes {
+		if len(familyCodes) == 0 {
+			log.Printf("No fault codes for %s, Fault_code is exported undecoded", family)
+		}
+	}
+}
+
+// Get the fault code dictionary for a table, or nil when its family has no
+// codes, so that Fault_code is left undecoded rather than marked unknown
+func tableFaultCodes(table string) map[string]FaultCode {
+	codes := FAULT_CODES[tableFamily(table)]
+	if len(codes) == 0 {
+		return nil
+	}
+	return codes
+}
+
+// Get the family a table belongs to, from the registry's PLC type or else
+// from the table name
+func tableFamily(table string) string {
+	if machine, ok := registry.lookup(table); ok {
+		switch machine.PLCType {
+		case FAMILY_S7_200, FAMILY_S7_1200, FAMILY_GT1000T:
+			return machine.PLCType
+		}
+	}
+
+	upper := strings.ToUpper(table)
+	switch {
+	case strings.Contains(upper, "GT1000T"):
+		return FAMILY_GT1000T
+	case strings.Contains(upper, "S7_1200"):
+		return FAMILY_S7_1200
+	case strings.Contains(upper, "S7_200"), strings.Contains(upper, "SMART200"):
+		return FAMILY_S7_200
+	default:
+		return ""
+	}
+}
+
+// Add the description, severity and action for a row's Fault_code
+func decorateFaultCode(row DataRow, codes map[string]FaultCode) {
+	value, exists := row["Fault_code"]
+	if !exists || value == "" {
+		return
+	}
+
+	code, ok := codes[formatTextValue(value)]
+	if !ok {
+		row["Fault_description"] = "Unknown fault code"
+		return
+	}
+
+	row["Fault_description"] = code.Description
+	row["Fault_severity"] = code.Severity
+	row["Fault_action"] = code.Action
+}
+
+// Handle fault code dictionary request
+func handleFaultCodes(c *gin.Context) {
+	table := c.Query("table")
+	if table == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"families":  FAULT_CODES,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	if !isTableAllowed(table) {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid table name"})
+		return
+	}
+
+	family := tableFamily(table)
+	codes := FAULT_CODES[family]
+	if codes == nil {
+		codes = map[string]FaultCode{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table":     table,
+		"family":    family,
+		"codes":     codes,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTableFamily(t *testing.T) {
+	tests := []struct {
+		table    string
+		expected string
+	}{
+		{"GTPL_108_gT_40E_P_S7_200_Germany", FAMILY_S7_200},
+		{"kabomachinedatasmart200", FAMILY_S7_200},
+		{"GTPL_114_GT_140E_S7_1200", FAMILY_S7_1200},
+		{"gtpl_122_s7_1200_01", FAMILY_S7_1200},
+		{"GTPL_121_GT1000T", FAMILY_GT1000T},
+		{"unknown", ""},
+	}
+
+	for _, test := range tests {
+		result := tableFamily(test.table)
+		if result != test.expected {
+			t.Errorf("tableFamily(%s) = %q, expected %q", test.table, result, test.expected)
+		}
+	}
+}
+
+func TestDecorateFaultCode(t *testing.T) {
+	codes := map[string]FaultCode{
+		"12": {Description: "Door open", Severity: "warning", Action: "Close the door"},
+	}
+
+	row := DataRow{"Fault_code": 12.0}
+	decorateFaultCode(row, codes)
+	if row["Fault_description"] != "Door open" || row["Fault_severity"] != "warning" || row["Fault_action"] != "Close the door" {
+		t.Errorf("decorateFaultCode(12) = %v", row)
+	}
+
+	row = DataRow{"Fault_code": int64(99)}
+	decorateFaultCode(row, codes)
+	if row["Fault_description"] != "Unknown fault code" {
+		t.Errorf("decorateFaultCode(99) = %v", row)
+	}
+
+	row = DataRow{"Fault_code": ""}
+	decorateFaultCode(row, codes)
+	if _, exists := row["Fault_description"]; exists {
+		t.Errorf("decorateFaultCode(empty) = %v", row)
+	}
+}
+
+func TestTableFaultCodes(t *testing.T) {
+	saved := FAULT_CODES
+	defer func() { FAULT_CODES = saved }()
+	FAULT_CODES = map[string]map[string]FaultCode{
+		FAMILY_S7_1200: {"12": {Description: "Door open"}},
+		FAMILY_S7_200:  {},
+	}
+
+	tests := []struct {
+		table    string
+		expected int
+	}{
+		{"GTPL_114_GT_140E_S7_1200", 1},
+		{"GTPL_108_gT_40E_P_S7_200_Germany", 0},
+		{"GTPL_121_GT1000T", 0},
+	}
+
+	for _, test := range tests {
+		result := tableFaultCodes(test.table)
+		if len(result) != test.expected || (test.expected == 0 && result != nil) {
+			t.Errorf("tableFaultCodes(%s) = %v, expected %d codes", test.table, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Most filter= conditions accepted in one request
+const MAX_FILTER_CONDITIONS = 20
+
+// Comparison operators, longest first so "<=" is not read as "<"
+var FILTER_OPERATORS = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// filterCondition is one parsed filter= condition. Conditions are
+// combined with AND.
+type filterCondition interface {
+	// sql renders the condition with ? placeholders for its values
+	sql() (string, []interface{})
+}
+
+// filterComparison compares a numeric or text column with a value
+type filterComparison struct {
+	Column TableColumn
+	Op     string
+	Value  interface{} // float64 for numeric columns, string otherwise
+}
+
+func (f filterComparison) sql() (string, []interface{}) {
+	op := f.Op
+	if op == "!=" {
+		op = "<>"
+	}
+	return fmt.Sprintf("`%s` %s ?", f.Column.Name, op), []interface{}{f.Value}
+}
+
+// filterFlag checks whether a fault flag is active, matching isTrueish
+type filterFlag struct {
+	Column TableColumn
+	Active bool
+}
+
+func (f filterFlag) sql() (string, []interface{}) {
+	return fmt.Sprintf("%s = %d", faultActiveExpr(f.Column), boolInt(f.Active)), nil
+}
+
+// filterFaults checks whether any fault flag is active (faults=any) or none is
+// (faults=none)
+type filterFaults struct {
+	Columns []TableColumn
+	Any     bool
+}
+
+func (f filterFaults) sql() (string, []interface{}) {
+	if len(f.Columns) == 0 {
+		if f.Any {
+			return "FALSE", nil
+		}
+		return "TRUE", nil
+	}
+
+	active := make([]string, len(f.Columns))
+	for i, column := range f.Columns {
+		active[i] = faultActiveExpr(column)
+	}
+	anyActive := "(" + strings.Join(active, " OR ") + ")"
+	if f.Any {
+		return anyActive, nil
+	}
+	return "NOT " + anyActive, nil
+}
+
+// Parse the filter= values, such as "HP_value>18;Fault_code!=0;faults=any",
+// and validate them against the table's columns. Each value holds one or
+// more ";" separated conditions; a raw ";" ends a query parameter, so it
+// must be sent as %3B, or the conditions as repeated filter= values.
+func parseFilter(exprs []string, tableColumns []TableColumn) ([]filterCondition, error) {
+	var parts []string
+	for _, expr := range exprs {
+		parts = append(parts, strings.Split(expr, ";")...)
+	}
+	if len(parts) > MAX_FILTER_CONDITIONS {
+		return nil, &RequestError{Message: "Invalid filter", Details: fmt.Sprintf("At most %d conditions are allowed", MAX_FILTER_CONDITIONS)}
+	}
+
+	var conditions []filterCondition
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		condition, err := parseFilterCondition(part, tableColumns)
+		if err != nil {
+			return nil, &RequestError{Message: "Invalid filter", Details: fmt.Sprintf("%s: %v", part, err)}
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// Parse one "<column><op><value>" condition
+func parseFilterCondition(part string, tableColumns []TableColumn) (filterCondition, error) {
+	name, op, value, ok := splitFilterCondition(part)
+	if !ok {
+		return nil, fmt.Errorf("expected <column><op><value> with one of %s", strings.Join(FILTER_OPERATORS, " "))
+	}
+
+	if strings.EqualFold(name, "faults") {
+		if op != "=" {
+			return nil, fmt.Errorf("faults only supports =any or =none")
+		}
+		var faultColumns []TableColumn
+		for _, column := range tableColumns {
+			if looksLikeFaultKey(column.Name) {
+				faultColumns = append(faultColumns, column)
+			}
+		}
+		switch strings.ToLower(value) {
+		case "any":
+			return filterFaults{Columns: faultColumns, Any: true}, nil
+		case "none":
+			return filterFaults{Columns: faultColumns}, nil
+		default:
+			return nil, fmt.Errorf("faults only supports =any or =none")
+		}
+	}
+
+	column, ok := findTableColumn(name, tableColumns)
+	if !ok {
+		return nil, fmt.Errorf("unknown column %s", name)
+	}
+
+	// Fault flags compare against true/false like isTrueish; numbers such as
+	// Fault_code!=0 are compared as numbers
+	if looksLikeFaultKey(column.Name) && (op == "=" || op == "!=") {
+		if active := strings.ToLower(value); active == "true" || active == "false" {
+			return filterFlag{Column: column, Active: (active == "true") == (op == "=")}, nil
+		}
+	}
+
+	if column.isNumeric() {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is numeric, got %q", column.Name, value)
+		}
+		return filterComparison{Column: column, Op: op, Value: number}, nil
+	}
+
+	if op != "=" && op != "!=" {
+		return nil, fmt.Errorf("%s is not numeric and only supports = and !=", column.Name)
+	}
+	return filterComparison{Column: column, Op: op, Value: strings.Trim(value, `"'`)}, nil
+}
+
+// Split a condition at its operator
+func splitFilterCondition(part string) (name, op, value string, ok bool) {
+	for i := range part {
+		for _, candidate := range FILTER_OPERATORS {
+			if strings.HasPrefix(part[i:], candidate) {
+				name = strings.TrimSpace(part[:i])
+				value = strings.TrimSpace(part[i+len(candidate):])
+				return name, candidate, value, name != "" && value != ""
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// Find a table column by name, ignoring case
+func findTableColumn(name string, tableColumns []TableColumn) (TableColumn, bool) {
+	for _, column := range tableColumns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	for _, column := range tableColumns {
+		if strings.EqualFold(column.Name, name) {
+			return column, true
+		}
+	}
+	return TableColumn{}, false
+}
+
+// Add filter conditions to a WHERE clause built by buildWhereClause
+func applyFilter(whereClause string, params []interface{}, conditions []filterCondition) (string, []interface{}) {
+	for _, condition := range conditions {
+		sql, values := condition.sql()
+		whereClause = appendCondition(whereClause, sql)
+		params = append(params, values...)
+	}
+	return whereClause, params
+}
+
+// Convert a bool to 0 or 1
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFilter(t *testing.T) {
+	tableColumns := []TableColumn{
+		{Name: "id", DataType: "int"},
+		{Name: "HP_value", DataType: "decimal"},
+		{Name: "Fault_code", DataType: "int"},
+		{Name: "door_open", DataType: "tinyint"},
+		{Name: "overheat_fault", DataType: "varchar"},
+		{Name: "mode", DataType: "varchar"},
+	}
+
+	tests := []struct {
+		exprs          []string
+		expectedWhere  string
+		expectedParams []interface{}
+	}{
+		{[]string{"HP_value>18"}, " WHERE `HP_value` > ?", []interface{}{18.0}},
+		{[]string{"hp_value >= 18.5; Fault_code!=0"}, " WHERE `HP_value` >= ? AND `Fault_code` <> ?", []interface{}{18.5, 0.0}},
+		{[]string{"hp_value >= 18.5", "Fault_code!=0"}, " WHERE `HP_value` >= ? AND `Fault_code` <> ?", []interface{}{18.5, 0.0}},
+		{[]string{"faults=any"}, " WHERE (COALESCE(`Fault_code` = 1, 0) OR COALESCE(`door_open` = 1, 0) OR COALESCE(LOWER(`overheat_fault`) IN ('true', '1'), 0))", nil},
+		{[]string{"faults=none"}, " WHERE NOT (COALESCE(`Fault_code` = 1, 0) OR COALESCE(`door_open` = 1, 0) OR COALESCE(LOWER(`overheat_fault`) IN ('true', '1'), 0))", nil},
+		{[]string{"door_open=true"}, " WHERE COALESCE(`door_open` = 1, 0) = 1", nil},
+		{[]string{"door_open!=true"}, " WHERE COALESCE(`door_open` = 1, 0) = 0", nil},
+		{[]string{"door_open=0"}, " WHERE `door_open` = ?", []interface{}{0.0}},
+		{[]string{"mode='auto'"}, " WHERE `mode` = ?", []interface{}{"auto"}},
+	}
+
+	for _, test := range tests {
+		conditions, err := parseFilter(test.exprs, tableColumns)
+		if err != nil {
+			t.Fatalf("parseFilter(%v) error: %v", test.exprs, err)
+		}
+		where, params := applyFilter("", nil, conditions)
+		if where != test.expectedWhere || !reflect.DeepEqual(params, test.expectedParams) {
+			t.Errorf("parseFilter(%v) = %q, %v, expected %q, %v", test.exprs, where, params, test.expectedWhere, test.expectedParams)
+		}
+	}
+
+	invalid := []string{
+		"missing>1",
+		"HP_value>high",
+		"HP_value",
+		"mode>auto",
+		"faults=some",
+		"faults>1",
+		"`id` = 1 OR 1=1 --",
+		"HP_value>",
+	}
+	for _, expr := range invalid {
+		if _, err := parseFilter([]string{expr}, tableColumns); err == nil {
+			t.Errorf("parseFilter(%s) returned no error", expr)
+		}
+	}
+}
+
+func TestApplyFilterKeepsRangeParams(t *testing.T) {
+	conditions := []filterCondition{filterComparison{Column: TableColumn{Name: "HP_value"}, Op: ">", Value: 18.0}}
+	where, params := applyFilter(" WHERE created_at >= ?", []interface{}{"2024-07-01 00:00:00"}, conditions)
+
+	expectedWhere := " WHERE created_at >= ? AND `HP_value` > ?"
+	expectedParams := []interface{}{"2024-07-01 00:00:00", 18.0}
+	if where != expectedWhere || !reflect.DeepEqual(params, expectedParams) {
+		t.Errorf("applyFilter() = %q, %v, expected %q, %v", where, params, expectedWhere, expectedParams)
+	}
+}
+
+func TestFilterQueryBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tableColumns := []TableColumn{{Name: "HP_value", DataType: "decimal"}, {Name: "Fault_code", DataType: "int"}}
+	expectedWhere := " WHERE `HP_value` > ? AND `Fault_code` <> ? AND NOT (COALESCE(`Fault_code` = 1, 0))"
+	expectedParams := []interface{}{18.0, 0.0}
+
+	// The documented ";" syntax percent-encoded, and repeated filter= values
+	tests := []string{
+		"filter=HP_value%3E18%3BFault_code!=0%3Bfaults=none",
+		"filter=HP_value%3E18&filter=Fault_code!=0&filter=faults=none",
+		"filter=HP_value%3E18%3BFault_code!=0&filter=faults=none",
+	}
+
+	for _, query := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/export?table=A&"+query, nil)
+
+		var req ExportRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			t.Fatalf("ShouldBindQuery(%s) error: %v", query, err)
+		}
+		conditions, err := parseFilter(req.Filters, tableColumns)
+		if err != nil {
+			t.Errorf("parseFilter(%v) error: %v", req.Filters, err)
+			continue
+		}
+
+		where, params := applyFilter("", nil, conditions)
+		if where != expectedWhere || !reflect.DeepEqual(params, expectedParams) {
+			t.Errorf("parseFilter(%v) = %q, %v, expected %q, %v", req.Filters, where, params, expectedWhere, expectedParams)
+		}
+	}
+}
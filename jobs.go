@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export job states
+const (
+	JOB_QUEUED    = "queued"
+	JOB_RUNNING   = "running"
+	JOB_COMPLETED = "completed"
+	JOB_FAILED    = "failed"
+	JOB_CANCELED  = "canceled"
+)
+
+// Maximum number of jobs waiting for a worker
+const JOB_QUEUE_SIZE = 100
+
+// ExportJob tracks an asynchronous export
+type ExportJob struct {
+	ID            string     `json:"id"`
+	State         string     `json:"state"`
+	Table         string     `json:"table"`
+	Format        string     `json:"format"`
+	RowsProcessed int64      `json:"rowsProcessed"`
+	TotalRows     int        `json:"totalRows"`
+	Progress      float64    `json:"progress"`
+	Filename      string     `json:"filename,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+
+	rows   int64 // updated atomically by the worker
+	req    ExportRequest
+	query  ExportQuery
+	format ExportFormat
+	path   string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// exportJobManager owns the job table, the queue and the worker pool
+type exportJobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*ExportJob
+	queue chan *ExportJob
+	dir   string
+	ttl   time.Duration
+}
+
+var exportJobs *exportJobManager
+
+// Initialize the export job manager and start its workers
+func initExportJobs() {
+	workers, err := strconv.Atoi(os.Getenv("EXPORT_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 2
+	}
+
+	dir := os.Getenv("EXPORT_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "export-api")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("Failed to create export directory: %v", err)
+	}
+
+	ttl, err := time.ParseDuration(os.Getenv("EXPORT_JOB_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	exportJobs = &exportJobManager{
+		jobs:  make(map[string]*ExportJob),
+		queue: make(chan *ExportJob, JOB_QUEUE_SIZE),
+		dir:   dir,
+		ttl:   ttl,
+	}
+
+	for i := 0; i < workers; i++ {
+		go exportJobs.worker()
+	}
+	go exportJobs.janitor()
+
+	log.Printf("Export workers started: %d workers, files in %s", workers, dir)
+}
+
+// Handle export job creation
+func handleCreateExportJob(c *gin.Context) {
+	var req ExportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid request parameters"})
+		return
+	}
+
+	format, err := validateExportRequest(&req)
+	if err != nil {
+		respondRequestError(c, err)
+		return
+	}
+	if len(req.Tables) > 1 {
+		respondRequestError(c, &RequestError{Message: "Multi-machine exports are only available from /export"})
+		return
+	}
+
+	// Resolve the query up front so bad parameters fail the request, not the job
+	query, err := prepareExportQuery(c.Request.Context(), req)
+	if err != nil {
+		if _, ok := err.(*RequestError); ok {
+			respondRequestError(c, err)
+			return
+		}
+		log.Printf("Error preparing export: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to read table columns"})
+		return
+	}
+
+	job, err := exportJobs.enqueue(req, query, format)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, ExportResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Handle export job status request
+func handleGetExportJob(c *gin.Context) {
+	job, ok := exportJobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ExportResponse{Error: "Export job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Handle export job download
+func handleDownloadExportJob(c *gin.Context) {
+	job, ok := exportJobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ExportResponse{Error: "Export job not found"})
+		return
+	}
+
+	if job.State != JOB_COMPLETED {
+		c.JSON(http.StatusConflict, ExportResponse{Error: "Export is not ready", Details: "Job state: " + job.State})
+		return
+	}
+
+	c.Header("Access-Control-Expose-Headers", "Content-Disposition")
+	c.Header("Content-Type", job.format.ContentType)
+	c.FileAttachment(job.path, job.Filename)
+}
+
+// Handle export job deletion: active jobs are canceled, finished jobs are removed
+func handleDeleteExportJob(c *gin.Context) {
+	job, ok := exportJobs.remove(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ExportResponse{Error: "Export job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Queue a new export job
+func (m *exportJobManager) enqueue(req ExportRequest, query ExportQuery, format ExportFormat) (ExportJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return ExportJob{}, fmt.Errorf("failed to create job id: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ExportJob{
+		ID:        id,
+		State:     JOB_QUEUED,
+		Table:     req.Table,
+		Format:    format.Extension,
+		CreatedAt: time.Now(),
+		req:       req,
+		query:     query,
+		format:    format,
+		path:      filepath.Join(m.dir, id+"."+format.Extension),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case m.queue <- job:
+	default:
+		cancel()
+		return ExportJob{}, errors.New("export queue is full, try again later")
+	}
+	m.jobs[id] = job
+
+	return m.snapshot(job), nil
+}
+
+// Get a copy of a job's current state
+func (m *exportJobManager) get(id string) (ExportJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	return m.snapshot(job), true
+}
+
+// Cancel an active job, or delete a finished job and its file
+func (m *exportJobManager) remove(id string) (ExportJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+
+	switch job.State {
+	case JOB_QUEUED, JOB_RUNNING:
+		// The worker records the canceled state once it notices
+		job.cancel()
+	default:
+		delete(m.jobs, id)
+		os.Remove(job.path)
+	}
+
+	return m.snapshot(job), true
+}
+
+// Copy a job for serialization; the caller must hold m.mu
+func (m *exportJobManager) snapshot(job *ExportJob) ExportJob {
+	copied := ExportJob{
+		ID:            job.ID,
+		State:         job.State,
+		Table:         job.Table,
+		Format:        job.Format,
+		RowsProcessed: atomic.LoadInt64(&job.rows),
+		TotalRows:     job.TotalRows,
+		Filename:      job.Filename,
+		Error:         job.Error,
+		CreatedAt:     job.CreatedAt,
+		StartedAt:     job.StartedAt,
+		FinishedAt:    job.FinishedAt,
+		format:        job.format,
+		path:          job.path,
+	}
+	if copied.TotalRows > 0 {
+		copied.Progress = float64(copied.RowsProcessed) / float64(copied.TotalRows)
+	} else if copied.State == JOB_COMPLETED {
+		copied.Progress = 1
+	}
+	return copied
+}
+
+// Process queued jobs until the queue is closed
+func (m *exportJobManager) worker() {
+	for job := range m.queue {
+		m.run(job)
+	}
+}
+
+// Run a single job and record its outcome
+func (m *exportJobManager) run(job *ExportJob) {
+	m.mu.Lock()
+	if job.ctx.Err() != nil {
+		job.State = JOB_CANCELED
+		m.finish(job)
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.State = JOB_RUNNING
+	job.StartedAt = &now
+	m.mu.Unlock()
+
+	err := m.execute(job)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case job.ctx.Err() != nil:
+		job.State = JOB_CANCELED
+		os.Remove(job.path)
+	case err != nil:
+		log.Printf("Export job %s failed: %v", job.ID, err)
+		job.State = JOB_FAILED
+		job.Error = err.Error()
+		os.Remove(job.path)
+	default:
+		job.State = JOB_COMPLETED
+	}
+	m.finish(job)
+}
+
+// Mark a job as finished; the caller must hold m.mu
+func (m *exportJobManager) finish(job *ExportJob) {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.cancel()
+	log.Printf("Export job %s %s after %d rows", job.ID, job.State, atomic.LoadInt64(&job.rows))
+}
+
+// Export a job's rows to its file on disk
+func (m *exportJobManager) execute(job *ExportJob) error {
+	req, query := job.req, job.query
+
+	tx, err := beginSnapshot(job.ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	totalCount, err := countExportRows(job.ctx, tx, query)
+	if err != nil {
+		return fmt.Errorf("error getting count: %v", err)
+	}
+
+	m.mu.Lock()
+	job.TotalRows = totalCount
+	job.Filename = exportFilename(req.Table, totalCount, job.format.Extension)
+	m.mu.Unlock()
+
+	file, err := os.Create(job.path)
+	if err != nil {
+		return fmt.Errorf("error creating export file: %v", err)
+	}
+	defer file.Close()
+
+	writer, err := job.format.NewWriter(file, query.Labels)
+	if err != nil {
+		return fmt.Errorf("error creating %s writer: %v", job.format.Extension, err)
+	}
+
+	if _, err := writeExport(job.ctx, &jobProgressWriter{RowWriter: writer, job: job}, tx, query, totalCount); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Periodically remove finished jobs older than the TTL
+func (m *exportJobManager) janitor() {
+	for now := range time.Tick(10 * time.Minute) {
+		m.sweep(now)
+	}
+}
+
+// Remove jobs that finished more than the TTL before now, and their files
+func (m *exportJobManager) sweep(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > m.ttl {
+			delete(m.jobs, id)
+			os.Remove(job.path)
+		}
+	}
+}
+
+// jobProgressWriter counts rows for progress reporting
+type jobProgressWriter struct {
+	RowWriter
+	job *ExportJob
+}
+
+func (p *jobProgressWriter) WriteRow(row DataRow) error {
+	if err := p.RowWriter.WriteRow(row); err != nil {
+		return err
+	}
+	atomic.AddInt64(&p.job.rows, 1)
+	return nil
+}
+
+// SetColumnTypes forwards column types to writers that need them
+func (p *jobProgressWriter) SetColumnTypes(types map[string]string) {
+	if tw, ok := p.RowWriter.(columnTypeSetter); ok {
+		tw.SetColumnTypes(types)
+	}
+}
+
+// SetLocation forwards the timestamp zone to writers that need it
+func (p *jobProgressWriter) SetLocation(loc *time.Location) {
+	if lw, ok := p.RowWriter.(locationSetter); ok {
+		lw.SetLocation(loc)
+	}
+}
+
+// WriteSheet forwards extra sheets to writers that support them
+func (p *jobProgressWriter) WriteSheet(name string, headers []string, rows [][]interface{}) error {
+	if sw, ok := p.RowWriter.(sheetWriter); ok {
+		return sw.WriteSheet(name, headers, rows)
+	}
+	return nil
+}
+
+// WriteCharts forwards charts to writers that support them
+func (p *jobProgressWriter) WriteCharts(name string, charts []ChartSpec, newestFirst bool) error {
+	if cw, ok := p.RowWriter.(chartWriter); ok {
+		return cw.WriteCharts(name, charts, newestFirst)
+	}
+	return nil
+}
+
+// Generate a random job id
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingWriter is a RowWriter that only counts rows
+type countingWriter struct {
+	rows int
+}
+
+func (w *countingWriter) WriteHeader(headers []string) error { return nil }
+func (w *countingWriter) WriteRow(row DataRow) error         { w.rows++; return nil }
+func (w *countingWriter) Flush() error                       { return nil }
+func (w *countingWriter) Close() error                       { return nil }
+
+func TestJobProgressWriter(t *testing.T) {
+	job := &ExportJob{ID: "test", State: JOB_RUNNING, TotalRows: 4}
+	inner := &countingWriter{}
+	w := &jobProgressWriter{RowWriter: inner, job: job}
+
+	for i := 0; i < 2; i++ {
+		if err := w.WriteRow(DataRow{"id": i}); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+
+	m := &exportJobManager{}
+	snapshot := m.snapshot(job)
+	if snapshot.RowsProcessed != 2 || snapshot.Progress != 0.5 {
+		t.Errorf("snapshot = %d rows, %v progress, expected 2 rows, 0.5 progress", snapshot.RowsProcessed, snapshot.Progress)
+	}
+	if inner.rows != 2 {
+		t.Errorf("inner writer got %d rows, expected 2", inner.rows)
+	}
+}
+
+// Create a job manager without workers, so tests run queued jobs themselves
+func newTestJobManager(t *testing.T, queueSize int) *exportJobManager {
+	return &exportJobManager{
+		jobs:  make(map[string]*ExportJob),
+		queue: make(chan *ExportJob, queueSize),
+		dir:   t.TempDir(),
+		ttl:   time.Hour,
+	}
+}
+
+// Serve db from a fake table for the rest of a test
+func useFakeDB(t *testing.T, table *fakeTable) {
+	saved := db
+	db = sql.OpenDB(table)
+	t.Cleanup(func() {
+		db.Close()
+		db = saved
+	})
+}
+
+// Get a fake machine table of three rows that also answers COUNT(*)
+func newJobTestTable() *fakeTable {
+	table := &fakeTable{columns: []string{"id", "created_at", "LP_value"}, types: []string{"INT", "DATETIME", "DECIMAL"}}
+	for id := 1; id <= 3; id++ {
+		table.rows = append(table.rows, []driver.Value{int64(id), time.Date(2024, 7, 1, 10, id, 0, 0, time.UTC), []byte("3.5")})
+	}
+	table.routes = map[string]*fakeTable{
+		"COUNT(": {columns: []string{"cnt"}, types: []string{"BIGINT"}, rows: [][]driver.Value{{int64(len(table.rows))}}},
+	}
+	return table
+}
+
+// Get a query exporting the fake table
+func newJobTestQuery() ExportQuery {
+	return ExportQuery{Table: "GTPL_110", Order: "asc", Zones: exportZones{Storage: time.UTC, Display: time.UTC}}
+}
+
+func TestExportJobEnqueue(t *testing.T) {
+	m := newTestJobManager(t, 1)
+
+	job, err := m.enqueue(ExportRequest{Table: "GTPL_110"}, newJobTestQuery(), EXPORT_FORMATS["csv"])
+	if err != nil {
+		t.Fatalf("enqueue() error: %v", err)
+	}
+	if job.State != JOB_QUEUED || job.Format != "csv" || job.Table != "GTPL_110" {
+		t.Errorf("enqueue() = %+v, expected a queued csv job for GTPL_110", job)
+	}
+	if got, ok := m.get(job.ID); !ok || got.ID != job.ID {
+		t.Errorf("get(%s) = %+v, %v, expected the queued job", job.ID, got, ok)
+	}
+
+	if _, err := m.enqueue(ExportRequest{Table: "GTPL_110"}, newJobTestQuery(), EXPORT_FORMATS["csv"]); err == nil {
+		t.Errorf("enqueue() on a full queue returned no error")
+	}
+	if len(m.jobs) != 1 {
+		t.Errorf("got %d jobs, expected the rejected job not to be kept", len(m.jobs))
+	}
+}
+
+func TestCreateExportJobQueueFull(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_110"}})
+	defer registry.set(nil)
+	useFakeDB(t, &fakeTable{
+		columns: []string{"COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE", "IS_NULLABLE"},
+		types:   []string{"VARCHAR", "VARCHAR", "VARCHAR", "VARCHAR"},
+		rows: [][]driver.Value{
+			{"id", "int", "int", "NO"},
+			{"created_at", "datetime", "datetime", "NO"},
+			{"LP_value", "decimal", "decimal(10,2)", "YES"},
+		},
+	})
+
+	saved := exportJobs
+	defer func() { exportJobs = saved }()
+	exportJobs = newTestJobManager(t, 0)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/exports?table=GTPL_110&format=csv", nil)
+	handleCreateExportJob(c)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("handleCreateExportJob() status = %d, expected %d: %s", recorder.Code, http.StatusServiceUnavailable, recorder.Body.String())
+	}
+}
+
+func TestExportJobRun(t *testing.T) {
+	useFakeDB(t, newJobTestTable())
+	failing := ExportFormat{Extension: "csv", NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+		return nil, errors.New("writer unavailable")
+	}}
+
+	tests := []struct {
+		format        ExportFormat
+		expectedState string
+		expectedRows  int64
+		expectedFile  bool
+	}{
+		{EXPORT_FORMATS["csv"], JOB_COMPLETED, 3, true},
+		{failing, JOB_FAILED, 0, false},
+	}
+
+	for _, test := range tests {
+		m := newTestJobManager(t, 1)
+		queued, err := m.enqueue(ExportRequest{Table: "GTPL_110"}, newJobTestQuery(), test.format)
+		if err != nil {
+			t.Fatalf("enqueue() error: %v", err)
+		}
+		m.run(<-m.queue)
+
+		job, _ := m.get(queued.ID)
+		if job.State != test.expectedState || job.RowsProcessed != test.expectedRows || job.TotalRows != 3 || job.FinishedAt == nil {
+			t.Errorf("run() = %+v, expected %s after %d of 3 rows", job, test.expectedState, test.expectedRows)
+		}
+		if _, err := os.Stat(job.path); (err == nil) != test.expectedFile {
+			t.Errorf("run() %s file exists = %v, expected %v", job.State, err == nil, test.expectedFile)
+		}
+		if test.expectedState == JOB_FAILED && job.Error == "" {
+			t.Errorf("run() failed without an error message")
+		}
+	}
+}
+
+func TestExportJobRemoveQueued(t *testing.T) {
+	useFakeDB(t, newJobTestTable())
+	m := newTestJobManager(t, 1)
+	queued, err := m.enqueue(ExportRequest{Table: "GTPL_110"}, newJobTestQuery(), EXPORT_FORMATS["csv"])
+	if err != nil {
+		t.Fatalf("enqueue() error: %v", err)
+	}
+
+	// The worker records the cancellation when it picks the job up
+	if job, ok := m.remove(queued.ID); !ok || job.State != JOB_QUEUED {
+		t.Errorf("remove(%s) = %+v, %v, expected the queued job", queued.ID, job, ok)
+	}
+	m.run(<-m.queue)
+
+	job, _ := m.get(queued.ID)
+	if job.State != JOB_CANCELED || job.StartedAt != nil || job.RowsProcessed != 0 {
+		t.Errorf("run() = %+v, expected a canceled job that never started", job)
+	}
+	if _, err := os.Stat(job.path); err == nil {
+		t.Errorf("run() created %s for a canceled job", job.path)
+	}
+
+	// Finished jobs are deleted
+	if _, ok := m.remove(queued.ID); !ok {
+		t.Errorf("remove(%s) of a canceled job = false, expected true", queued.ID)
+	}
+	if _, ok := m.get(queued.ID); ok {
+		t.Errorf("get(%s) found a removed job", queued.ID)
+	}
+}
+
+// blockingWriter holds the first row until released
+type blockingWriter struct {
+	countingWriter
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) WriteRow(row DataRow) error {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	return w.countingWriter.WriteRow(row)
+}
+
+func TestExportJobRemoveRunning(t *testing.T) {
+	useFakeDB(t, newJobTestTable())
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	format := ExportFormat{Extension: "csv", NewWriter: func(io.Writer, *HeaderProfile) (RowWriter, error) { return w, nil }}
+
+	m := newTestJobManager(t, 1)
+	queued, err := m.enqueue(ExportRequest{Table: "GTPL_110"}, newJobTestQuery(), format)
+	if err != nil {
+		t.Fatalf("enqueue() error: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.run(<-m.queue)
+		close(done)
+	}()
+
+	<-w.started
+	if job, ok := m.remove(queued.ID); !ok || job.State != JOB_RUNNING {
+		t.Errorf("remove(%s) = %+v, %v, expected the running job", queued.ID, job, ok)
+	}
+	close(w.release)
+	<-done
+
+	job, _ := m.get(queued.ID)
+	if job.State != JOB_CANCELED || job.RowsProcessed != 1 {
+		t.Errorf("run() = %+v, expected canceled after 1 of 3 rows", job)
+	}
+	if _, err := os.Stat(job.path); err == nil {
+		t.Errorf("run() kept %s for a canceled job", job.path)
+	}
+}
+
+func TestExportJobSweep(t *testing.T) {
+	m := newTestJobManager(t, 0)
+	now := time.Now()
+	finished := func(age time.Duration) *time.Time {
+		at := now.Add(-age)
+		return &at
+	}
+
+	tests := []struct {
+		id         string
+		finishedAt *time.Time
+		expected   bool // kept
+	}{
+		{"expired", finished(2 * time.Hour), false},
+		{"recent", finished(30 * time.Minute), true},
+		{"running", nil, true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(m.dir, test.id+".csv")
+		if err := os.WriteFile(path, []byte("id\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m.jobs[test.id] = &ExportJob{ID: test.id, FinishedAt: test.finishedAt, path: path}
+	}
+
+	m.sweep(now)
+	for _, test := range tests {
+		_, kept := m.jobs[test.id]
+		_, statErr := os.Stat(filepath.Join(m.dir, test.id+".csv"))
+		if kept != test.expected || (statErr == nil) != test.expected {
+			t.Errorf("sweep() kept %s = %v (file %v), expected %v", test.id, kept, statErr == nil, test.expected)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -12,7 +13,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/xuri/excelize/v2"
 )
 
 // Database connection
@@ -21,29 +21,9 @@ var db *sql.DB
 // Constants
 const (
 	CHUNK_SIZE = 10000 // Process 10k rows at a time to manage memory
-)
 
-// Allowed tables
-var ALLOWED_TABLES = []string{
-	"GTPL_108_gT_40E_P_S7_200_Germany",
-	"GTPL_109_gT_40E_P_S7_200_Germany",
-	"GTPL_110_gT_40E_P_S7_200_Germany",
-	"GTPL_111_gT_80E_P_S7_200_Germany",
-	"GTPL_112_gT_80E_P_S7_200_Germany",
-	"GTPL_113_gT_80E_P_S7_200_Germany",
-	"kabomachinedatasmart200",
-	"GTPL_114_GT_140E_S7_1200",
-	"GTPL_115_GT_180E_S7_1200",
-	"GTPL_119_GT_180E_S7_1200",
-	"GTPL_120_GT_180E_S7_1200",
-	"GTPL_116_GT_240E_S7_1200",
-	"GTPL_117_GT_320E_S7_1200",
-	"GTPL_121_GT1000T",
-	"gtpl_122_s7_1200_01",
-	"GTPL_124_GT_450T_S7_1200",
-	"GTPL_131_GT_650T_S7_1200",
-	"GTPL_132_GT_650T_S7_1200",
-}
+	XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
 
 // Preferred numeric column order
 var PREFERRED_NUMERIC_ORDER = []string{
@@ -56,13 +36,13 @@ var PREFERRED_NUMERIC_ORDER = []string{
 	"Running_time_hour", "Running_time_minute", "Running_hours", "Running_hours_min",
 	"Blower_speed", "Hot_valve_speed", "AHT_vale_speed", "AHT_valve_speed", "Heater_speed", "Cond_fan_speed",
 	"Blower_speed_set_in_manual", "Cond_fan_speed_set_in_manual", "Hot_gas_valve_set_in_manual", "AHT_valve_set_in_manual", "Heater_set_in_manual",
-	"Fault_code", "FS", "UF", "RHP", "BLWR_pct", "RMR_pct", "CNPR_pct", "AHT_pct", "HCSR_pct",
+	"Fault_code", "Fault_description", "Fault_severity", "Fault_action", "FS", "UF", "RHP", "BLWR_pct", "RMR_pct", "CNPR_pct", "AHT_pct", "HCSR_pct",
 }
 
 // Pretty header mapping
 var PRETTY_HEADER_MAP = map[string]string{
 	"id":                           "Record#",
-	"created_at":                   "Date & Time (IST)",
+	"created_at":                   "Date & Time",
 	"created_at_date":              "Date",
 	"created_at_time":              "Time",
 	"T2_1_ambient_temp":            "T2-1 Ambient Temp (°C)",
@@ -102,6 +82,9 @@ var PRETTY_HEADER_MAP = map[string]string{
 	"Running_hours":                "Total Running Hours",
 	"Running_hours_min":            "Total Running Minutes",
 	"Fault_code":                   "Fault Code",
+	"Fault_description":            "Fault Description",
+	"Fault_severity":               "Fault Severity",
+	"Fault_action":                 "Recommended Action",
 	"UF":                           "UF*",
 	"RHP":                          "RHP",
 	"BLWR_pct":                     "BLWR%",
@@ -111,6 +94,8 @@ var PRETTY_HEADER_MAP = map[string]string{
 	"HCSR_pct":                     "HCSR%",
 	"FS":                           "FS",
 	"Faults":                       "Faults",
+	"row_count":                    "Samples",
+	"fault_rows":                   "Rows With Faults",
 }
 
 // Fault patterns
@@ -123,12 +108,47 @@ type DataRow map[string]interface{}
 
 // ExportRequest represents the export request parameters
 type ExportRequest struct {
-	Table    string `form:"table"`
-	FromDate string `form:"fromDate"`
-	ToDate   string `form:"toDate"`
-	All      string `form:"all"`
-	Limit    string `form:"limit"`
-	Order    string `form:"order"`
+	Table     string   `form:"table"`
+	Tables    []string `form:"table"` // every table= value
+	Site      string   `form:"site"`  // all registered machines at a site
+	FromDate  string   `form:"fromDate"`
+	ToDate    string   `form:"toDate"`
+	FromTime  string   `form:"fromTime"`
+	ToTime    string   `form:"toTime"`
+	Last      string   `form:"last"`
+	Filters   []string `form:"filter"` // every filter= value, each holding ";" separated conditions
+	All       string   `form:"all"`
+	Limit     string   `form:"limit"`
+	Order     string   `form:"order"`
+	Format    string   `form:"format"`
+	Headers   string   `form:"headers"`
+	Columns   string   `form:"columns"`
+	Exclude   string   `form:"exclude"`
+	Aggregate string   `form:"aggregate"`
+	Episodes  string   `form:"episodes"`
+	Summary   string   `form:"summary"`
+	Charts    string   `form:"charts"`
+	Split     string   `form:"split"`
+	Profile   string   `form:"profile"`
+	TZ        string   `form:"tz"`
+}
+
+// ExportQuery holds the resolved query for streaming one table
+type ExportQuery struct {
+	Table       string
+	WhereClause string
+	Params      []interface{}
+	Order       string
+	Pretty      bool
+	Columns     *ColumnSelection     // nil exports every column
+	Aggregate   *AggregateSpec       // nil exports individual rows
+	Episodes    bool                 // add a fault episode sheet where supported
+	Summary     bool                 // add a summary statistics sheet where supported
+	Charts      bool                 // add a sheet of line charts where supported
+	FaultCodes  map[string]FaultCode // decodes Fault_code in pretty mode
+	Profile     *HeaderProfile       // column labels, order and hidden columns
+	Labels      *HeaderProfile       // header labels, nil for raw column keys
+	Zones       exportZones          // storage and display zone of created_at
 }
 
 // ExportResponse represents the export response
@@ -137,11 +157,40 @@ type ExportResponse struct {
 	Details string `json:"details,omitempty"`
 }
 
+// RequestError is a validation failure reported to the client as a 400
+type RequestError struct {
+	Message string
+	Details string
+}
+
+func (e *RequestError) Error() string {
+	if e.Details != "" {
+		return e.Message + ": " + e.Details
+	}
+	return e.Message
+}
+
 func main() {
 	// Initialize database connection
 	initDB()
 	defer db.Close()
 
+	// Load registered machines and their storage zones
+	loadStorageZone()
+	initMachineRegistry()
+
+	// Load fault code descriptions
+	loadFaultCodes()
+
+	// Load per-model header profiles
+	loadHeaderProfiles()
+
+	// Load the rows per sheet before XLSX exports roll over
+	loadSheetRowLimit()
+
+	// Start background export workers
+	initExportJobs()
+
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
@@ -155,8 +204,20 @@ func main() {
 	r.GET("/export", handleExport)
 	r.OPTIONS("/export", handleOptions)
 	r.GET("/tables", handleTables)
+	r.GET("/tables/:table/schema", handleTableSchema)
+	r.GET("/compare", handleCompare)
 	r.GET("/status", handleStatus)
 
+	// Asynchronous export jobs
+	r.POST("/exports", handleCreateExportJob)
+	r.GET("/exports/:id", handleGetExportJob)
+	r.GET("/exports/:id/file", handleDownloadExportJob)
+	r.DELETE("/exports/:id", handleDeleteExportJob)
+
+	// Fault reports
+	r.GET("/faults/episodes", handleFaultEpisodes)
+	r.GET("/faults/codes", handleFaultCodes)
+
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		// Check database connection
@@ -241,7 +302,7 @@ func initDB() {
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type")
 		c.Header("Cache-Control", "no-store, max-age=0")
 
@@ -261,9 +322,11 @@ func handleOptions(c *gin.Context) {
 
 // Handle tables list request
 func handleTables(c *gin.Context) {
+	machines := registry.list()
 	c.JSON(http.StatusOK, gin.H{
-		"tables":    ALLOWED_TABLES,
-		"count":     len(ALLOWED_TABLES),
+		"tables":    registry.tables(),
+		"machines":  machines,
+		"count":     len(machines),
 		"timestamp": time.Now().Format(time.RFC3339),
 	})
 }
@@ -294,25 +357,43 @@ func handleExport(c *gin.Context) {
 		return
 	}
 
-	// Validate table
-	if req.Table == "" || !isTableAllowed(req.Table) {
-		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid or missing table name"})
+	format, err := validateExportRequest(&req)
+	if err != nil {
+		respondRequestError(c, err)
 		return
 	}
 
-	// Set defaults
-	if req.All == "" {
-		req.All = "true"
+	if len(req.Tables) > 1 {
+		handleMultiExport(c, req)
+		return
 	}
-	if req.Order == "" {
-		req.Order = "desc"
+
+	// Stop querying as soon as the client goes away
+	ctx := c.Request.Context()
+
+	query, err := prepareExportQuery(ctx, req)
+	if err != nil {
+		if _, ok := err.(*RequestError); ok {
+			respondRequestError(c, err)
+			return
+		}
+		log.Printf("Error preparing export: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to read table columns"})
+		return
 	}
 
-	// Build WHERE clause
-	whereClause, params := buildWhereClause(req.FromDate, req.ToDate)
+	// Count and chunks are read from one snapshot so new rows arriving
+	// mid-export cannot change the exported set
+	tx, err := beginSnapshot(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to get record count"})
+		return
+	}
+	defer tx.Rollback()
 
 	// Get total count
-	totalCount, err := getTotalCount(req.Table, whereClause, params)
+	totalCount, err := countExportRows(ctx, tx, query)
 	if err != nil {
 		log.Printf("Error getting count: %v", err)
 		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to get record count"})
@@ -321,57 +402,235 @@ func handleExport(c *gin.Context) {
 
 	log.Printf("Total matching records: %d", totalCount)
 
-	// Process data
-	processedRows, err := processDataInChunks(req.Table, whereClause, params, req.Order, totalCount, req.All == "true")
+	// Generate filename
+	filename := exportFilename(req.Table, totalCount, format.Extension)
+
+	// Set response headers
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Access-Control-Expose-Headers", "Content-Disposition")
+
+	writer, err := format.NewWriter(c.Writer, query.Labels)
 	if err != nil {
-		log.Printf("Error processing data: %v", err)
-		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to process data"})
+		log.Printf("Error creating %s writer: %v", format.Extension, err)
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to create export file"})
 		return
 	}
 
-	// Create Excel file
-	excelBuffer, err := createExcelFile(processedRows, req.All == "true")
+	// Stream rows straight into the response
+	rowCount, err := writeExport(ctx, writer, tx, query, totalCount)
+	if ctx.Err() != nil {
+		log.Printf("Export of %s canceled by client after %d / %d rows", req.Table, rowCount, totalCount)
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating Excel file: %v", err)
-		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to create Excel file"})
+		log.Printf("Error streaming export after %d rows: %v", rowCount, err)
+		// Once the body has started we can only abort the transfer
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to process data"})
+		}
 		return
 	}
 
-	// Generate filename
-	filename := fmt.Sprintf("%s_%s_%drecords.xlsx", req.Table, time.Now().Format("2006-01-02"), len(processedRows))
+	log.Printf("Exported %d records from %s", rowCount, req.Table)
+}
 
-	// Set response headers
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Header("Access-Control-Expose-Headers", "Content-Disposition")
+// Build the download filename for an export, named after the machine when
+// the registry has a name for the table
+func exportFilename(table string, totalCount int, extension string) string {
+	return fmt.Sprintf("%s_%s_%drecords.%s", exportBaseName(table), time.Now().Format("2006-01-02"), totalCount, extension)
+}
 
-	// Send file
-	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", excelBuffer)
+// Get the name export files of a table start with: the machine name when
+// registered, else the table
+func exportBaseName(table string) string {
+	if machine, ok := registry.lookup(table); ok && machine.Name != "" {
+		return strings.Trim(unsafeFilenameChars.ReplaceAllString(machine.Name, "_"), "_")
+	}
+	return table
 }
 
-// Check if table is allowed
-func isTableAllowed(table string) bool {
-	for _, allowedTable := range ALLOWED_TABLES {
-		if allowedTable == table {
-			return true
+// Validate export parameters, apply defaults and resolve the output format
+func validateExportRequest(req *ExportRequest) (ExportFormat, error) {
+	// Validate tables
+	tables, err := resolveExportTables(*req)
+	if err != nil {
+		return ExportFormat{}, err
+	}
+	req.Table, req.Tables = tables[0], tables
+
+	// Set defaults
+	if req.All == "" {
+		req.All = "true"
+	}
+	if req.Order == "" {
+		req.Order = "desc"
+	}
+	if req.Format == "" {
+		req.Format = "xlsx"
+	}
+
+	// Validate format
+	format, ok := EXPORT_FORMATS[strings.ToLower(req.Format)]
+	if !ok {
+		return ExportFormat{}, &RequestError{Message: "Invalid format", Details: "Supported formats: " + strings.Join(exportFormatNames(), ", ")}
+	}
+
+	// Several machines need a sheet each
+	if len(req.Tables) > 1 {
+		if format.Extension != "xlsx" {
+			return ExportFormat{}, &RequestError{Message: "Multi-machine exports are only available as xlsx"}
+		}
+		if req.Episodes == "true" {
+			return ExportFormat{}, &RequestError{Message: "Fault episodes are not available for multi-machine exports"}
+		}
+		if req.Summary == "true" {
+			return ExportFormat{}, &RequestError{Message: "Summary sheets are not available for multi-machine exports"}
+		}
+		if req.Charts == "true" {
+			return ExportFormat{}, &RequestError{Message: "Charts are not available for multi-machine exports"}
+		}
+		if req.Split != "" {
+			return ExportFormat{}, &RequestError{Message: "Split exports are not available for multi-machine exports"}
 		}
 	}
-	return false
+
+	// Statistics and episodes are computed from individual rows
+	if req.Summary == "true" && req.Aggregate != "" {
+		return ExportFormat{}, &RequestError{Message: "Summary sheets are not available for aggregated exports"}
+	}
+	if req.Episodes == "true" && req.Aggregate != "" {
+		return ExportFormat{}, &RequestError{Message: "Fault episodes are not available for aggregated exports"}
+	}
+
+	// Split exports are a ZIP of files in the requested format
+	if req.Split != "" {
+		if _, isPeriod := SPLIT_PERIODS[req.Split]; !isPeriod && req.Split != "rows" {
+			return ExportFormat{}, &RequestError{Message: "Invalid split", Details: "Supported splits: rows, day, month"}
+		}
+		if !containsString(SPLIT_FORMATS, format.Extension) {
+			return ExportFormat{}, &RequestError{Message: "Invalid format for split export", Details: "Supported formats: " + strings.Join(SPLIT_FORMATS, ", ")}
+		}
+		// Extra sheets and charts cover the whole export, not one file
+		if req.Summary == "true" || req.Episodes == "true" || req.Charts == "true" {
+			return ExportFormat{}, &RequestError{Message: "Summary, episodes and charts are not available for split exports"}
+		}
+		format = splitFormat(format, req.Table, req.Split)
+	}
+
+	return format, nil
+}
+
+// Resolve a validated export request into the query to run
+func prepareExportQuery(ctx context.Context, req ExportRequest) (ExportQuery, error) {
+	zones, err := resolveZones(req.TZ, req.Table)
+	if err != nil {
+		return ExportQuery{}, err
+	}
+
+	bounds, err := parseTimeRange(req, zones, time.Now())
+	if err != nil {
+		return ExportQuery{}, err
+	}
+	whereClause, params := buildWhereClause(bounds, zones)
+
+	query := ExportQuery{
+		Table:       req.Table,
+		WhereClause: whereClause,
+		Params:      params,
+		Order:       req.Order,
+		Pretty:      req.All == "true",
+		Episodes:    req.Episodes == "true",
+		Summary:     req.Summary == "true",
+		Charts:      req.Charts == "true",
+		Zones:       zones,
+	}
+	if query.Pretty {
+		query.FaultCodes = tableFaultCodes(req.Table)
+	}
+
+	query.Profile, err = resolveProfile(req.Profile, req.Table)
+	if err != nil {
+		return query, err
+	}
+	if req.Headers != "raw" {
+		query.Labels = query.Profile.withUnit("created_at", zones.label())
+	}
+
+	hidesColumns := query.Pretty && len(query.Profile.Hidden) > 0
+	if req.Columns == "" && req.Exclude == "" && req.Aggregate == "" && len(req.Filters) == 0 && !hidesColumns {
+		return query, nil
+	}
+
+	// Column selection, filters and aggregation are validated against the table's real columns
+	tableColumns, err := getTableColumns(ctx, req.Table)
+	if err != nil {
+		return query, fmt.Errorf("error reading table columns: %v", err)
+	}
+
+	if len(req.Filters) > 0 {
+		conditions, err := parseFilter(req.Filters, tableColumns)
+		if err != nil {
+			return query, err
+		}
+		query.WhereClause, query.Params = applyFilter(query.WhereClause, query.Params, conditions)
+	}
+
+	query.Columns, err = buildColumnSelection(tableColumnNames(tableColumns), splitList(req.Columns), splitList(req.Exclude), query.Pretty, query.Profile)
+	if err != nil {
+		return query, err
+	}
+
+	// Period splits name each file after created_at, so it is read even when
+	// it is excluded; filterHeaders still keeps it out of the files
+	if _, ok := SPLIT_PERIODS[req.Split]; ok {
+		query.Columns.fetch("created_at")
+	}
+
+	if req.Aggregate != "" {
+		query.Aggregate, err = buildAggregateSpec(req.Aggregate, tableColumns, query.Columns, query.Profile)
+		if err != nil {
+			return query, err
+		}
+		if err := zones.checkConvertTZ(ctx, db); err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+// Respond with 400 for a RequestError, or a generic message for anything else
+func respondRequestError(c *gin.Context, err error) {
+	if reqErr, ok := err.(*RequestError); ok {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: reqErr.Message, Details: reqErr.Details})
+		return
+	}
+	c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid request parameters", Details: err.Error()})
+}
+
+// Check if table is allowed
+func isTableAllowed(table string) bool {
+	_, ok := registry.lookup(table)
+	return ok
 }
 
 // Build WHERE clause for date filtering
-func buildWhereClause(fromDate, toDate string) (string, []interface{}) {
+func buildWhereClause(bounds timeRange, zones exportZones) (string, []interface{}) {
 	var conditions []string
 	var params []interface{}
 
-	if fromDate != "" {
-		conditions = append(conditions, "created_at >= CONCAT(?, ' 00:00:00')")
-		params = append(params, fromDate)
+	// Bounds are compared as wall-clock times in the storage zone
+	if bounds.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		params = append(params, zones.storageValue(*bounds.From))
 	}
 
-	if toDate != "" {
-		conditions = append(conditions, "created_at < DATE_ADD(CONCAT(?, ' 00:00:00'), INTERVAL 1 DAY)")
-		params = append(params, toDate)
+	if bounds.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		params = append(params, zones.storageValue(*bounds.To))
 	}
 
 	if len(conditions) == 0 {
@@ -381,66 +640,114 @@ func buildWhereClause(fromDate, toDate string) (string, []interface{}) {
 	return " WHERE " + strings.Join(conditions, " AND "), params
 }
 
+// Append a condition to a WHERE clause built by buildWhereClause
+func appendCondition(whereClause, condition string) string {
+	if whereClause == "" {
+		return " WHERE " + condition
+	}
+	return whereClause + " AND " + condition
+}
+
+// Begin a read-only transaction with a consistent snapshot. InnoDB fixes the
+// snapshot at the first read, so every query in the transaction sees the same rows.
+// The transaction is rolled back and its connection released when ctx is done.
+func beginSnapshot(ctx context.Context) (*sql.Tx, error) {
+	return db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+}
+
 // Get total count of records
-func getTotalCount(table, whereClause string, params []interface{}) (int, error) {
+func getTotalCount(ctx context.Context, tx *sql.Tx, table, whereClause string, params []interface{}) (int, error) {
 	query := fmt.Sprintf("SELECT COUNT(*) AS cnt FROM `%s`%s", table, whereClause)
 
 	var count int
-	err := db.QueryRow(query, params...).Scan(&count)
+	err := tx.QueryRowContext(ctx, query, params...).Scan(&count)
 	return count, err
 }
 
-// Process data in chunks
-func processDataInChunks(table, whereClause string, params []interface{}, order string, totalCount int, pretty bool) ([]DataRow, error) {
-	var allProcessedRows []DataRow
-	offset := 0
+// Count the rows an export will produce: source rows, or buckets when aggregating
+func countExportRows(ctx context.Context, tx *sql.Tx, q ExportQuery) (int, error) {
+	if q.Aggregate != nil {
+		return countBuckets(ctx, tx, q)
+	}
+	return getTotalCount(ctx, tx, q.Table, q.WhereClause, q.Params)
+}
+
+// Process data in chunks, handing each processed chunk to fn so that only
+// one chunk is held in memory at a time. Chunks are paged by id (keyset
+// pagination) rather than OFFSET, so each query is an index range scan.
+func processDataInChunks(ctx context.Context, tx *sql.Tx, q ExportQuery, totalCount int, fn func(columns []*sql.ColumnType, chunk []DataRow) error) error {
+	processed := 0
 
-	// Determine order
+	// Determine order and the matching keyset condition
 	orderClause := "DESC"
-	if strings.ToLower(order) == "asc" {
+	keysetCondition := "id < ?"
+	if strings.ToLower(q.Order) == "asc" {
 		orderClause = "ASC"
+		keysetCondition = "id > ?"
 	}
 
-	for offset < totalCount {
+	var lastID interface{}
+
+	for processed < totalCount {
 		currentChunkSize := CHUNK_SIZE
-		if offset+currentChunkSize > totalCount {
-			currentChunkSize = totalCount - offset
+		if processed+currentChunkSize > totalCount {
+			currentChunkSize = totalCount - processed
 		}
 
+		// Continue after the last id of the previous chunk
+		chunkWhere := q.WhereClause
+		chunkParams := make([]interface{}, 0, len(q.Params)+2)
+		chunkParams = append(chunkParams, q.Params...)
+		if lastID != nil {
+			chunkWhere = appendCondition(chunkWhere, keysetCondition)
+			chunkParams = append(chunkParams, lastID)
+		}
+		chunkParams = append(chunkParams, currentChunkSize)
+
 		// Query chunk
-		query := fmt.Sprintf("SELECT * FROM `%s`%s ORDER BY id %s LIMIT ? OFFSET ?", table, whereClause, orderClause)
-		chunkParams := append(params, currentChunkSize, offset)
+		query := fmt.Sprintf("SELECT %s FROM `%s`%s ORDER BY id %s LIMIT ?", q.Columns.selectClause(), q.Table, chunkWhere, orderClause)
 
-		rows, err := db.Query(query, chunkParams...)
+		rows, err := tx.QueryContext(ctx, query, chunkParams...)
 		if err != nil {
-			return nil, fmt.Errorf("error querying chunk: %v", err)
+			return fmt.Errorf("error querying chunk: %v", err)
 		}
 
 		// Process chunk
-		chunkRows, err := processChunk(rows, pretty)
+		columns, chunkRows, err := processChunk(rows, q.Pretty, q.Zones)
 		rows.Close()
 		if err != nil {
-			return nil, fmt.Errorf("error processing chunk: %v", err)
+			return fmt.Errorf("error processing chunk: %v", err)
 		}
 
-		allProcessedRows = append(allProcessedRows, chunkRows...)
-		offset += currentChunkSize
+		if len(chunkRows) == 0 {
+			break
+		}
+		lastID = chunkRows[len(chunkRows)-1]["id"]
+
+		if err := fn(columns, chunkRows); err != nil {
+			return err
+		}
+		processed += len(chunkRows)
 
 		// Log progress
-		if offset%(CHUNK_SIZE*5) == 0 {
-			log.Printf("Processed %d / %d records", offset, totalCount)
+		if processed%(CHUNK_SIZE*5) == 0 {
+			log.Printf("Processed %d / %d records", processed, totalCount)
 		}
 	}
 
-	return allProcessedRows, nil
+	return nil
 }
 
 // Process a single chunk of data
-func processChunk(rows *sql.Rows, pretty bool) ([]DataRow, error) {
-	columns, err := rows.Columns()
+func processChunk(rows *sql.Rows, pretty bool, zones exportZones) ([]*sql.ColumnType, []DataRow, error) {
+	columnTypes, err := rows.ColumnTypes()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	columns := columnNames(columnTypes)
 
 	var chunkRows []DataRow
 
@@ -454,7 +761,7 @@ func processChunk(rows *sql.Rows, pretty bool) ([]DataRow, error) {
 
 		// Scan row
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// Convert to map
@@ -463,6 +770,8 @@ func processChunk(rows *sql.Rows, pretty bool) ([]DataRow, error) {
 			val := values[i]
 			if val == nil {
 				row[col] = ""
+			} else if col == "created_at" {
+				row[col] = zones.toDisplay(val)
 			} else {
 				row[col] = val
 			}
@@ -478,7 +787,42 @@ func processChunk(rows *sql.Rows, pretty bool) ([]DataRow, error) {
 		chunkRows = append(chunkRows, row)
 	}
 
-	return chunkRows, nil
+	return columnTypes, chunkRows, rows.Err()
+}
+
+// Get the names of the columns with a numeric MySQL type
+func numericColumnNames(columnTypes []*sql.ColumnType) []string {
+	var names []string
+	for _, columnType := range columnTypes {
+		if isNumericTypeName(columnType.DatabaseTypeName()) {
+			names = append(names, columnType.Name())
+		}
+	}
+	return names
+}
+
+// Check if a driver type name such as "DECIMAL" or "UNSIGNED INT" is numeric
+func isNumericTypeName(name string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "unsigned ")
+	return NUMERIC_DATA_TYPES[name]
+}
+
+// Get the MySQL type name of each column, such as "DECIMAL"
+func columnTypeNames(columnTypes []*sql.ColumnType) map[string]string {
+	types := make(map[string]string, len(columnTypes))
+	for _, columnType := range columnTypes {
+		types[columnType.Name()] = columnType.DatabaseTypeName()
+	}
+	return types
+}
+
+// Get the names of a set of columns
+func columnNames(columnTypes []*sql.ColumnType) []string {
+	names := make([]string, len(columnTypes))
+	for i, columnType := range columnTypes {
+		names[i] = columnType.Name()
+	}
+	return names
 }
 
 // Process row for pretty format
@@ -505,7 +849,7 @@ func processPrettyRow(row DataRow) DataRow {
 	}
 
 	// Order numeric columns
-	ordered := orderNumericColumns(numericKeys)
+	ordered := orderNumericColumns(numericKeys, PREFERRED_NUMERIC_ORDER)
 	for _, k := range ordered {
 		if v, exists := row[k]; exists {
 			processed[k] = toNum(v)
@@ -570,11 +914,11 @@ func normalizeCreatedAt(raw interface{}) map[string]string {
 }
 
 // Order numeric columns based on preference
-func orderNumericColumns(numericKeys []string) []string {
+func orderNumericColumns(numericKeys, preferredOrder []string) []string {
 	ordered := make([]string, 0, len(numericKeys))
 
 	// Add preferred columns first
-	for _, preferred := range PREFERRED_NUMERIC_ORDER {
+	for _, preferred := range preferredOrder {
 		for _, key := range numericKeys {
 			if key == preferred {
 				ordered = append(ordered, key)
@@ -638,8 +982,13 @@ func isTrueish(v interface{}) bool {
 		return val
 	case string:
 		return strings.ToLower(val) == "true" || val == "1"
+	case []byte:
+		// Text protocol results arrive as bytes
+		return isTrueish(string(val))
 	case int:
 		return val == 1
+	case int64:
+		return val == 1
 	case float64:
 		return val == 1
 	default:
@@ -662,6 +1011,13 @@ func toNum(v interface{}) interface{} {
 			return f
 		}
 		return ""
+	case []byte:
+		// DECIMAL and text arrive as bytes
+		return toNum(string(val))
+	case float32:
+		// Widen through the shortest text form to keep 0.1 from becoming 0.10000000149
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(val), 'g', -1, 32), 64)
+		return f
 	case int, int64, float64:
 		return val
 	default:
@@ -669,62 +1025,112 @@ func toNum(v interface{}) interface{} {
 	}
 }
 
-// Create Excel file
-func createExcelFile(rows []DataRow, pretty bool) ([]byte, error) {
-	f := excelize.NewFile()
-	defer f.Close()
+// Stream all matching rows through a RowWriter, returning the number of rows written
+func writeExport(ctx context.Context, w RowWriter, tx *sql.Tx, q ExportQuery, totalCount int) (int, error) {
+	if q.Aggregate != nil {
+		return writeAggregateExport(ctx, w, tx, q)
+	}
 
-	if len(rows) == 0 {
-		// Create empty sheet
-		f.SetCellValue("Sheet1", "A1", "id")
-		f.SetCellValue("Sheet1", "B1", "created_at")
-		f.SetCellValue("Sheet1", "A2", "No records found for selected criteria")
-	} else {
-		// Get headers
-		var headers []string
-		if pretty {
-			headers = getPrettyHeaders(rows)
-		} else {
-			headers = getRawHeaders(rows)
-		}
+	defer w.Close()
+
+	var headers []string
+	var summary *exportSummary
+	rowCount := 0
 
-		// Write headers
-		for i, header := range headers {
-			prettyHeader := PRETTY_HEADER_MAP[header]
-			if prettyHeader == "" {
-				prettyHeader = header
+	err := processDataInChunks(ctx, tx, q, totalCount, func(columns []*sql.ColumnType, chunk []DataRow) error {
+		if q.FaultCodes != nil {
+			for _, row := range chunk {
+				decorateFaultCode(row, q.FaultCodes)
 			}
-			cell := fmt.Sprintf("%c1", 'A'+i)
-			f.SetCellValue("Sheet1", cell, prettyHeader)
 		}
 
-		// Write data
-		for rowIdx, row := range rows {
-			for colIdx, header := range headers {
-				if value, exists := row[header]; exists {
-					cell := fmt.Sprintf("%c%d", 'A'+colIdx, rowIdx+2)
-					f.SetCellValue("Sheet1", cell, value)
+		// Headers are fixed by the first chunk since they must precede any rows
+		if headers == nil {
+			if q.Pretty {
+				numeric := numericColumnNames(columns)
+				if q.FaultCodes != nil && containsString(numeric, "Fault_code") {
+					numeric = append(numeric, FAULT_CODE_DERIVED...)
 				}
+				headers = getPrettyHeaders(numeric, chunk, q.Profile)
+			} else {
+				headers = getRawHeaders(columnNames(columns))
+			}
+			headers = q.Columns.filterHeaders(headers)
+			if tw, ok := w.(columnTypeSetter); ok {
+				tw.SetColumnTypes(columnTypeNames(columns))
+			}
+			if lw, ok := w.(locationSetter); ok {
+				lw.SetLocation(q.Zones.Display)
+			}
+			if err := w.WriteHeader(headers); err != nil {
+				return err
+			}
+			if q.Summary {
+				summary = newExportSummary(headers)
 			}
 		}
 
-		// Auto-fit columns
-		for i := range headers {
-			col := string(rune('A' + i))
-			f.SetColWidth("Sheet1", col, col, 15)
+		for _, row := range chunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := w.WriteRow(row); err != nil {
+				return err
+			}
+			if summary != nil {
+				summary.observe(row)
+			}
+			rowCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return rowCount, err
+	}
+
+	if headers == nil {
+		if err := w.WriteHeader(q.Columns.filterHeaders([]string{"id", "created_at"})); err != nil {
+			return rowCount, err
+		}
+	}
+
+	if err := writeCharts(w, q); err != nil {
+		return rowCount, err
+	}
+
+	sw, isSheetWriter := w.(sheetWriter)
+	if isSheetWriter && q.Summary {
+		if summary == nil {
+			summary = newExportSummary(nil)
+		}
+		if err := sw.WriteSheet(SUMMARY_SHEET, nil, summary.sheet(q.Labels, q.Zones.label())); err != nil {
+			return rowCount, err
 		}
 	}
 
-	// Rename sheet
-	f.SetSheetName("Sheet1", "Data")
+	if isSheetWriter && q.Episodes {
+		if err := writeFaultEpisodeSheet(ctx, sw, tx, q); err != nil {
+			return rowCount, err
+		}
+	}
 
-	// Write to buffer
-	return f.WriteToBuffer()
+	return rowCount, w.Flush()
 }
 
-// Get headers for pretty format
-func getPrettyHeaders(rows []DataRow) []string {
-	if len(rows) == 0 {
+// Add the profile's charts when requested and supported by the writer
+func writeCharts(w RowWriter, q ExportQuery) error {
+	cw, ok := w.(chartWriter)
+	if !ok || !q.Charts {
+		return nil
+	}
+	return cw.WriteCharts(CHARTS_SHEET, q.Profile.charts(), strings.ToLower(q.Order) != "asc")
+}
+
+// Get headers for pretty format: the table's numeric columns, which are
+// known up front even when the first rows hold no value for them, plus any
+// other keys carrying numbers in the given rows
+func getPrettyHeaders(numeric []string, rows []DataRow, profile *HeaderProfile) []string {
+	if len(numeric) == 0 && len(rows) == 0 {
 		return []string{"id", "created_at", "created_at_date", "created_at_time"}
 	}
 
@@ -732,6 +1138,11 @@ func getPrettyHeaders(rows []DataRow) []string {
 
 	// Get all numeric keys
 	allKeys := make(map[string]bool)
+	for _, k := range numeric {
+		if k != "id" && k != "created_at" {
+			allKeys[k] = true
+		}
+	}
 	for _, row := range rows {
 		for k := range row {
 			if k != "id" && k != "created_at" && k != "created_at_date" && k != "created_at_time" && k != "Faults" {
@@ -747,20 +1158,15 @@ func getPrettyHeaders(rows []DataRow) []string {
 	}
 
 	// Order dynamic keys
-	orderedDynamic := orderNumericColumns(dynamic)
+	orderedDynamic := orderNumericColumns(dynamic, profile.columnOrder())
 
 	return append(fixed, append(orderedDynamic, "Faults")...)
 }
 
-// Get headers for raw format
-func getRawHeaders(rows []DataRow) []string {
-	if len(rows) == 0 {
+// Get headers for raw format, keeping the table's column order
+func getRawHeaders(columns []string) []string {
+	if len(columns) == 0 {
 		return []string{"id", "created_at"}
 	}
-
-	headers := make([]string, 0, len(rows[0]))
-	for k := range rows[0] {
-		headers = append(headers, k)
-	}
-	return headers
+	return columns
 }
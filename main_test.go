@@ -1,10 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestIsTableAllowed(t *testing.T) {
+	machines, _, err := loadMachineFile("config/machines.json")
+	if err != nil {
+		t.Fatalf("loadMachineFile() error: %v", err)
+	}
+	registry.set(machines)
+
 	tests := []struct {
 		table    string
 		expected bool
@@ -55,6 +70,9 @@ func TestIsTrueish(t *testing.T) {
 		{"0", false},
 		{1, true},
 		{0, false},
+		{int64(1), true},
+		{int64(0), false},
+		{[]byte("1"), true},
 		{nil, false},
 	}
 
@@ -77,6 +95,9 @@ func TestToNum(t *testing.T) {
 		{42, 42},
 		{3.14, 3.14},
 		{"invalid", ""},
+		{[]byte("12.50"), 12.5},
+		{[]byte("open"), ""},
+		{float32(0.1), 0.1},
 	}
 
 	for _, test := range tests {
@@ -86,3 +107,253 @@ func TestToNum(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendCondition(t *testing.T) {
+	tests := []struct {
+		whereClause string
+		condition   string
+		expected    string
+	}{
+		{"", "id > ?", " WHERE id > ?"},
+		{" WHERE created_at >= ?", "id < ?", " WHERE created_at >= ? AND id < ?"},
+	}
+
+	for _, test := range tests {
+		result := appendCondition(test.whereClause, test.condition)
+		if result != test.expected {
+			t.Errorf("appendCondition(%q, %q) = %q, expected %q", test.whereClause, test.condition, result, test.expected)
+		}
+	}
+}
+
+func TestValidateExportRequestSplit(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_110", Name: "GTPL 110"}, {Table: "GTPL_111"}})
+	defer registry.set(nil)
+
+	tests := []struct {
+		req               ExportRequest
+		expectedExtension string
+		expectedError     bool
+	}{
+		{ExportRequest{Table: "GTPL_110"}, "xlsx", false},
+		{ExportRequest{Table: "GTPL_110", Split: "rows"}, "zip", false},
+		{ExportRequest{Table: "GTPL_110", Split: "rows", Format: "csv"}, "zip", false},
+		{ExportRequest{Table: "GTPL_110", Split: "rows", Format: "parquet"}, "", true},
+		{ExportRequest{Table: "GTPL_110", Split: "day"}, "zip", false},
+		{ExportRequest{Table: "GTPL_110", Split: "month", Format: "tsv"}, "zip", false},
+		{ExportRequest{Table: "GTPL_110", Split: "day", Format: "json"}, "", true},
+		{ExportRequest{Table: "GTPL_110", Split: "hours"}, "", true},
+		{ExportRequest{Tables: []string{"GTPL_110", "GTPL_111"}, Split: "rows"}, "", true},
+		{ExportRequest{Table: "GTPL_110", Split: "rows", Summary: "true"}, "", true},
+		{ExportRequest{Table: "GTPL_110", Split: "day", Episodes: "true"}, "", true},
+		{ExportRequest{Table: "GTPL_110", Split: "month", Charts: "true"}, "", true},
+	}
+
+	for _, test := range tests {
+		format, err := validateExportRequest(&test.req)
+		if (err != nil) != test.expectedError || format.Extension != test.expectedExtension {
+			t.Errorf("validateExportRequest(%+v) = %q, %v, expected %q", test.req, format.Extension, err, test.expectedExtension)
+		}
+	}
+}
+
+func TestGetPrettyHeaders(t *testing.T) {
+	// HP_value is NULL in every row seen so far, yet its column is numeric
+	rows := []DataRow{
+		{"id": int64(1), "created_at": "2024-07-01 10:00:00", "LP_value": 3.5, "Faults": ""},
+	}
+
+	result := getPrettyHeaders([]string{"id", "LP_value", "HP_value"}, rows, DEFAULT_PROFILE)
+	expected := []string{"id", "created_at", "created_at_date", "created_at_time", "LP_value", "HP_value", "Faults"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("getPrettyHeaders() = %v, expected %v", result, expected)
+	}
+}
+
+func TestIsNumericTypeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"DECIMAL", true},
+		{"UNSIGNED INT", true},
+		{"TINYINT", true},
+		{"DOUBLE", true},
+		{"DATETIME", false},
+		{"VARCHAR", false},
+	}
+
+	for _, test := range tests {
+		if result := isNumericTypeName(test.name); result != test.expected {
+			t.Errorf("isNumericTypeName(%s) = %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
+
+// fakeTable is a database/sql connector serving the same rows for every
+// query, enough to drive writeExport without MySQL. Queries containing a key
+// of routes are served by that table instead.
+type fakeTable struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+	routes  map[string]*fakeTable
+}
+
+func (f *fakeTable) Connect(ctx context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeTable) Driver() driver.Driver                            { return nil }
+func (f *fakeTable) Close() error                                     { return nil }
+func (f *fakeTable) Begin() (driver.Tx, error)                        { return f, nil }
+func (f *fakeTable) Commit() error                                    { return nil }
+func (f *fakeTable) Rollback() error                                  { return nil }
+
+// BeginTx accepts the read-only snapshot options of beginSnapshot
+func (f *fakeTable) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return f, nil
+}
+
+func (f *fakeTable) Prepare(query string) (driver.Stmt, error) {
+	for key, table := range f.routes {
+		if strings.Contains(query, key) {
+			return fakeStmt{table}, nil
+		}
+	}
+	return fakeStmt{f}, nil
+}
+
+type fakeStmt struct {
+	table *fakeTable
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{table: s.table}, nil
+}
+
+type fakeRows struct {
+	table *fakeTable
+	next  int
+}
+
+func (r *fakeRows) Columns() []string                       { return r.table.columns }
+func (r *fakeRows) Close() error                            { return nil }
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string { return r.table.types[i] }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.table.rows) {
+		return io.EOF
+	}
+	copy(dest, r.table.rows[r.next])
+	r.next++
+	return nil
+}
+
+// cancelingWriter cancels the export after a number of rows
+type cancelingWriter struct {
+	countingWriter
+	after  int
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) WriteRow(row DataRow) error {
+	w.countingWriter.WriteRow(row)
+	if w.rows == w.after {
+		w.cancel()
+	}
+	return nil
+}
+
+func TestWriteExportCanceled(t *testing.T) {
+	table := &fakeTable{columns: []string{"id", "created_at", "LP_value"}, types: []string{"INT", "DATETIME", "DECIMAL"}}
+	for id := 5; id >= 1; id-- {
+		table.rows = append(table.rows, []driver.Value{int64(id), []byte("2024-07-01 10:00:00"), []byte("3.5")})
+	}
+	database := sql.OpenDB(table)
+	defer database.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error: %v", err)
+	}
+	defer tx.Rollback()
+
+	w := &cancelingWriter{after: 2, cancel: cancel}
+	q := ExportQuery{Table: "GTPL_110", Order: "desc", Zones: exportZones{Storage: time.UTC, Display: time.UTC}}
+	rowCount, err := writeExport(ctx, w, tx, q, len(table.rows))
+	if err != context.Canceled {
+		t.Errorf("writeExport() error = %v, expected %v", err, context.Canceled)
+	}
+	if rowCount != 2 || w.rows != 2 {
+		t.Errorf("writeExport() wrote %d rows (writer got %d), expected 2 of %d", rowCount, w.rows, len(table.rows))
+	}
+}
+
+func TestWriteExportSplitExcludedCreatedAt(t *testing.T) {
+	table := &fakeTable{columns: []string{"id", "created_at", "LP_value"}, types: []string{"INT", "DATETIME", "DECIMAL"}}
+	// DATETIME values arrive as time.Time with parseTime=true
+	for id, hour := range []int{10, 11, 33} {
+		at := time.Date(2024, 7, 1, hour, 0, 0, 0, time.UTC)
+		table.rows = append(table.rows, []driver.Value{int64(id + 1), at, []byte("3.5")})
+	}
+	database := sql.OpenDB(table)
+	defer database.Close()
+
+	tx, err := database.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error: %v", err)
+	}
+	defer tx.Rollback()
+
+	columns, err := buildColumnSelection(table.columns, nil, []string{"created_at"}, false, nil)
+	if err != nil {
+		t.Fatalf("buildColumnSelection() error: %v", err)
+	}
+	columns.fetch("created_at")
+
+	var buf bytes.Buffer
+	w := newZipWriter(&buf, EXPORT_FORMATS["csv"], nil, "GTPL_110", "day")
+	q := ExportQuery{Table: "GTPL_110", Order: "asc", Columns: columns, Zones: exportZones{Storage: time.UTC, Display: time.UTC}}
+	if _, err := writeExport(context.Background(), w, tx, q, len(table.rows)); err != nil {
+		t.Fatalf("writeExport() error: %v", err)
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	expected := map[string]string{
+		"GTPL_110_2024-07-01.csv": "id,LP_value\n1,3.5\n2,3.5\n",
+		"GTPL_110_2024-07-02.csv": "id,LP_value\n3,3.5\n",
+	}
+	for name, content := range expected {
+		if result := strings.ReplaceAll(entries[name], "\r\n", "\n"); result != content {
+			t.Errorf("%s = %q, expected %q", name, result, content)
+		}
+	}
+}
+
+func TestValidateExportRequestAggregate(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_110"}})
+	defer registry.set(nil)
+
+	tests := []struct {
+		req           ExportRequest
+		expectedError bool
+	}{
+		{ExportRequest{Table: "GTPL_110", Aggregate: "1h"}, false},
+		{ExportRequest{Table: "GTPL_110", Aggregate: "1h", Charts: "true"}, false},
+		{ExportRequest{Table: "GTPL_110", Aggregate: "1h", Summary: "true"}, true},
+		{ExportRequest{Table: "GTPL_110", Aggregate: "1h", Episodes: "true"}, true},
+	}
+
+	for _, test := range tests {
+		if _, err := validateExportRequest(&test.req); (err != nil) != test.expectedError {
+			t.Errorf("validateExportRequest(%+v) error = %v, expected error %v", test.req, err, test.expectedError)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Most tables of a multi-machine export queried at once, each holding one
+// DB connection
+const MULTI_EXPORT_CONNECTIONS = 4
+
+// Sheet listing the machines of a multi-machine workbook
+const INDEX_SHEET = "Index"
+
+// Characters Excel does not allow in sheet names
+var invalidSheetChars = regexp.MustCompile(`[\[\]:*?/\\]`)
+
+// Resolve table= (repeatable) and site= into the tables to export, in
+// request order followed by registry order
+func resolveExportTables(req ExportRequest) ([]string, error) {
+	requested := req.Tables
+	if len(requested) == 0 && req.Table != "" {
+		requested = []string{req.Table}
+	}
+
+	if req.Site != "" {
+		machines := registry.site(req.Site)
+		if len(machines) == 0 {
+			return nil, &RequestError{Message: "Unknown site", Details: req.Site}
+		}
+		for _, machine := range machines {
+			requested = append(requested, machine.Table)
+		}
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, table := range requested {
+		if table == "" || !isTableAllowed(table) {
+			return nil, &RequestError{Message: "Invalid or missing table name", Details: table}
+		}
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+
+	if len(tables) == 0 {
+		return nil, &RequestError{Message: "Invalid or missing table name"}
+	}
+	return tables, nil
+}
+
+// feedItem is a header or a row passed from a table's export to the workbook
+type feedItem struct {
+	headers []string
+	row     DataRow
+}
+
+// tableFeed is a RowWriter that hands one table's rows to the goroutine
+// writing the workbook. rows and err are set before items is closed.
+type tableFeed struct {
+	ctx   context.Context
+	items chan feedItem
+	rows  int
+	err   error
+}
+
+func (f *tableFeed) WriteHeader(headers []string) error {
+	return f.send(feedItem{headers: headers})
+}
+
+func (f *tableFeed) WriteRow(row DataRow) error {
+	return f.send(feedItem{row: row})
+}
+
+func (f *tableFeed) Flush() error {
+	return nil
+}
+
+func (f *tableFeed) Close() error {
+	return nil
+}
+
+// Pass an item on, giving up once the export is canceled
+func (f *tableFeed) send(item feedItem) error {
+	select {
+	case f.items <- item:
+		return nil
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	}
+}
+
+// Export one table into the feed from its own snapshot
+func (f *tableFeed) run(q ExportQuery) {
+	defer close(f.items)
+
+	tx, err := beginSnapshot(f.ctx)
+	if err != nil {
+		f.err = fmt.Errorf("error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	totalCount, err := countExportRows(f.ctx, tx, q)
+	if err != nil {
+		f.err = fmt.Errorf("error getting count: %v", err)
+		return
+	}
+
+	f.rows, f.err = writeExport(f.ctx, f, tx, q, totalCount)
+}
+
+// Export several tables into one workbook with a sheet per machine and an
+// index sheet. Up to MULTI_EXPORT_CONNECTIONS tables are queried
+// concurrently while the sheets are written one at a time in table order.
+// Returns the number of rows written per table.
+func writeMultiExport(ctx context.Context, x *xlsxWriter, queries []ExportQuery) ([]int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feeds := make([]*tableFeed, len(queries))
+	for i := range feeds {
+		feeds[i] = &tableFeed{ctx: ctx, items: make(chan feedItem, CHUNK_SIZE)}
+	}
+
+	// Tables start in order, so the one being written always holds a connection
+	go func() {
+		connections := make(chan struct{}, MULTI_EXPORT_CONNECTIONS)
+		for i, feed := range feeds {
+			select {
+			case connections <- struct{}{}:
+			case <-ctx.Done():
+				for _, skipped := range feeds[i:] {
+					skipped.err = ctx.Err()
+					close(skipped.items)
+				}
+				return
+			}
+
+			go func(feed *tableFeed, q ExportQuery) {
+				defer func() { <-connections }()
+				feed.run(q)
+			}(feed, queries[i])
+		}
+	}()
+
+	if err := x.ReserveSheet(INDEX_SHEET); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(queries))
+	usedNames := map[string]bool{strings.ToLower(INDEX_SHEET): true}
+	indexRows := make([][]interface{}, len(queries))
+
+	for i, feed := range feeds {
+		q := queries[i]
+		name := machineSheetName(q.Table, usedNames)
+		if err := x.NextSheet(name, q.Labels); err != nil {
+			return counts, err
+		}
+
+		for item := range feed.items {
+			var err error
+			if item.headers != nil {
+				err = x.WriteHeader(item.headers)
+			} else {
+				err = x.WriteRow(item.row)
+			}
+			if err != nil {
+				return counts, err
+			}
+		}
+		if feed.err != nil {
+			return counts, fmt.Errorf("error exporting %s: %v", q.Table, feed.err)
+		}
+		counts[i] = feed.rows
+
+		machine, _ := registry.lookup(q.Table)
+		indexRows[i] = []interface{}{name, machine.Name, q.Table, machine.Site, machine.Model, feed.rows}
+		log.Printf("Exported %d records from %s into sheet %s", feed.rows, q.Table, name)
+	}
+
+	headers := []string{"Sheet", "Machine", "Table", "Site", "Model", "Rows"}
+	return counts, x.WriteSheet(INDEX_SHEET, headers, indexRows)
+}
+
+// Get a unique sheet name for a table, from the machine name if registered.
+// Sheet names are limited to 31 characters.
+func machineSheetName(table string, used map[string]bool) string {
+	name := table
+	if machine, ok := registry.lookup(table); ok && machine.Name != "" {
+		name = machine.Name
+	}
+	name = strings.Trim(invalidSheetChars.ReplaceAllString(name, "_"), "'")
+	name = truncateRunes(name, 31)
+
+	unique := name
+	for n := 2; used[strings.ToLower(unique)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		unique = truncateRunes(name, 31-len(suffix)) + suffix
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// Cut a string to at most n runes
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// Handle an export of several machines into one workbook
+func handleMultiExport(c *gin.Context, req ExportRequest) {
+	ctx := c.Request.Context()
+
+	queries := make([]ExportQuery, len(req.Tables))
+	for i, table := range req.Tables {
+		tableReq := req
+		tableReq.Table = table
+
+		query, err := prepareExportQuery(ctx, tableReq)
+		if err != nil {
+			if reqErr, ok := err.(*RequestError); ok {
+				respondRequestError(c, &RequestError{Message: reqErr.Message, Details: table + ": " + reqErr.Details})
+				return
+			}
+			log.Printf("Error preparing export of %s: %v", table, err)
+			c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to read table columns"})
+			return
+		}
+		queries[i] = query
+	}
+
+	x, err := newXLSXWriter(c.Writer, nil)
+	if err != nil {
+		log.Printf("Error creating xlsx writer: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to create export file"})
+		return
+	}
+	defer x.Close()
+
+	// The workbook is only written out on Flush, so errors can still be reported as JSON
+	counts, err := writeMultiExport(ctx, x, queries)
+	if ctx.Err() != nil {
+		log.Printf("Multi-machine export canceled by client")
+		return
+	}
+	if err != nil {
+		log.Printf("Error writing multi-machine export: %v", err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to process data"})
+		return
+	}
+
+	totalCount := 0
+	for _, count := range counts {
+		totalCount += count
+	}
+
+	name := fmt.Sprintf("%d_machines", len(req.Tables))
+	if req.Site != "" {
+		name = strings.Trim(unsafeFilenameChars.ReplaceAllString(req.Site, "_"), "_")
+	}
+	filename := exportFilename(name, totalCount, "xlsx")
+
+	c.Header("Content-Type", XLSX_CONTENT_TYPE)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Access-Control-Expose-Headers", "Content-Disposition")
+
+	if err := x.Flush(); err != nil {
+		log.Printf("Error writing workbook: %v", err)
+		return
+	}
+
+	log.Printf("Exported %d records from %d machines", totalCount, len(req.Tables))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestResolveExportTables(t *testing.T) {
+	registry.set([]Machine{
+		{Table: "GTPL_108_gT_40E_P_S7_200_Germany", Site: "Plant A"},
+		{Table: "GTPL_109_gT_40E_P_S7_200_Germany", Site: "Plant A"},
+		{Table: "GTPL_121_GT1000T", Site: "Plant B"},
+	})
+	defer registry.set(nil)
+
+	tests := []struct {
+		req      ExportRequest
+		expected []string
+	}{
+		{ExportRequest{Table: "GTPL_121_GT1000T"}, []string{"GTPL_121_GT1000T"}},
+		{ExportRequest{Tables: []string{"GTPL_121_GT1000T", "GTPL_108_gT_40E_P_S7_200_Germany"}}, []string{"GTPL_121_GT1000T", "GTPL_108_gT_40E_P_S7_200_Germany"}},
+		{ExportRequest{Site: "plant a"}, []string{"GTPL_108_gT_40E_P_S7_200_Germany", "GTPL_109_gT_40E_P_S7_200_Germany"}},
+		{ExportRequest{Tables: []string{"GTPL_109_gT_40E_P_S7_200_Germany"}, Site: "Plant A"}, []string{"GTPL_109_gT_40E_P_S7_200_Germany", "GTPL_108_gT_40E_P_S7_200_Germany"}},
+	}
+
+	for _, test := range tests {
+		result, err := resolveExportTables(test.req)
+		if err != nil || !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("resolveExportTables(%+v) = %v, %v, expected %v", test.req, result, err, test.expected)
+		}
+	}
+
+	invalid := []ExportRequest{
+		{},
+		{Site: "Plant C"},
+		{Tables: []string{"GTPL_121_GT1000T", "invalid_table"}},
+	}
+	for _, req := range invalid {
+		if _, err := resolveExportTables(req); err == nil {
+			t.Errorf("resolveExportTables(%+v) returned no error", req)
+		}
+	}
+}
+
+func TestMachineSheetName(t *testing.T) {
+	registry.set([]Machine{
+		{Table: "GTPL_108_gT_40E_P_S7_200_Germany", Name: "GTPL 108 [Line 1/2]"},
+		{Table: "GTPL_109_gT_40E_P_S7_200_Germany", Name: "GTPL 108 [Line 1/2]"},
+	})
+	defer registry.set(nil)
+
+	used := map[string]bool{"index": true}
+	tests := []struct {
+		table    string
+		expected string
+	}{
+		{"GTPL_108_gT_40E_P_S7_200_Germany", "GTPL 108 _Line 1_2_"},
+		{"GTPL_109_gT_40E_P_S7_200_Germany", "GTPL 108 _Line 1_2_ (2)"},
+		{"GTPL_131_GT_650T_S7_1200_with_a_long_suffix", "GTPL_131_GT_650T_S7_1200_with_a"},
+		{"Index", "Index (2)"},
+	}
+
+	for _, test := range tests {
+		result := machineSheetName(test.table, used)
+		if result != test.expected {
+			t.Errorf("machineSheetName(%s) = %q, expected %q", test.table, result, test.expected)
+		}
+		if len([]rune(result)) > 31 {
+			t.Errorf("machineSheetName(%s) = %q is longer than 31 characters", test.table, result)
+		}
+	}
+}
+
+func TestXLSXWriterNextSheet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, nil)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.ReserveSheet(INDEX_SHEET); err != nil {
+		t.Fatalf("ReserveSheet() error: %v", err)
+	}
+	for i, name := range []string{"GTPL 108", "GTPL 109"} {
+		if err := w.NextSheet(name, DEFAULT_PROFILE); err != nil {
+			t.Fatalf("NextSheet(%s) error: %v", name, err)
+		}
+		if err := w.WriteHeader([]string{"id", "LP_value"}); err != nil {
+			t.Fatalf("WriteHeader() error: %v", err)
+		}
+		if i == 0 {
+			if err := w.WriteRow(DataRow{"id": 1, "LP_value": 2.5}); err != nil {
+				t.Fatalf("WriteRow() error: %v", err)
+			}
+		}
+	}
+	if err := w.WriteSheet(INDEX_SHEET, []string{"Sheet", "Rows"}, [][]interface{}{{"GTPL 108", 1}, {"GTPL 109", 0}}); err != nil {
+		t.Fatalf("WriteSheet() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	expectedSheets := []string{INDEX_SHEET, "GTPL 108", "GTPL 109"}
+	if sheets := f.GetSheetList(); !reflect.DeepEqual(sheets, expectedSheets) {
+		t.Errorf("GetSheetList() = %v, expected %v", sheets, expectedSheets)
+	}
+
+	cells := []struct {
+		sheet    string
+		cell     string
+		expected string
+	}{
+		{INDEX_SHEET, "A3", "GTPL 109"},
+		{"GTPL 108", "B1", "LP Value"},
+		{"GTPL 108", "B2", "2.5"},
+		{"GTPL 109", "A2", "No records found for selected criteria"},
+	}
+	for _, test := range cells {
+		if value, _ := f.GetCellValue(test.sheet, test.cell); value != test.expected {
+			t.Errorf("%s!%s = %q, expected %q", test.sheet, test.cell, value, test.expected)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// HeaderProfile defines how pretty exports of one machine model label, order
+// and hide columns. Keys a profile does not mention fall back to
+// PRETTY_HEADER_MAP and PREFERRED_NUMERIC_ORDER.
+type HeaderProfile struct {
+	Name   string            `json:"-"`
+	Labels map[string]string `json:"labels"` // column key to label, without unit
+	Units  map[string]string `json:"units"`  // column key to unit, appended as "Label (unit)"
+	Order  []string          `json:"order"`  // preferred numeric column order
+	Hidden []string          `json:"hidden"` // columns left out unless requested with columns=
+	Charts []ChartSpec       `json:"charts"` // charts=true line charts, DEFAULT_CHARTS if empty
+	// set-point ranges highlighted in XLSX exports, DEFAULT_LIMITS if empty
+	Limits map[string]ColumnLimit `json:"limits"`
+}
+
+// ColumnLimit bounds a column's values by set-point columns or fixed numbers
+type ColumnLimit struct {
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+}
+
+// Limits checked for profiles that do not define their own
+var DEFAULT_LIMITS = map[string]ColumnLimit{
+	"LP_value": {Min: "LP_set_point"},
+	"HP_value": {Max: "HP_set_point"},
+}
+
+// ChartSpec is a line chart of numeric columns against created_at
+type ChartSpec struct {
+	Title   string   `json:"title"`
+	Columns []string `json:"columns"`
+	Axis    string   `json:"axis,omitempty"` // value axis title
+}
+
+// Charts drawn for profiles that do not define their own
+var DEFAULT_CHARTS = []ChartSpec{
+	{Title: "Mean Temperatures", Columns: []string{"T0_temp_mean", "T1_temp_mean", "T2_temp_mean", "TH_temp_mean"}, Axis: "°C"},
+	{Title: "Pressures", Columns: []string{"LP_value", "HP_value"}},
+}
+
+// Profile used when neither the request nor the machine registry names one
+var DEFAULT_PROFILE = &HeaderProfile{Name: "default"}
+
+// Header profiles keyed by name
+var HEADER_PROFILES = map[string]*HeaderProfile{}
+
+// Load header profiles from PROFILES_FILE
+func loadHeaderProfiles() {
+	path := os.Getenv("PROFILES_FILE")
+	if path == "" {
+		path = "config/profiles.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Header profiles not loaded: %v", err)
+		return
+	}
+
+	profiles := map[string]*HeaderProfile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		log.Printf("Header profiles %s are invalid: %v", path, err)
+		return
+	}
+	for name, profile := range profiles {
+		profile.Name = name
+	}
+
+	HEADER_PROFILES = profiles
+	log.Printf("Loaded %d header profiles from %s", len(profiles), path)
+}
+
+// Get the profile for an export: the one named by profile=, else the one set
+// for the machine in the registry, else the default
+func resolveProfile(name, table string) (*HeaderProfile, error) {
+	if name != "" {
+		if name == DEFAULT_PROFILE.Name {
+			return DEFAULT_PROFILE, nil
+		}
+		if profile, ok := HEADER_PROFILES[name]; ok {
+			return profile, nil
+		}
+		return nil, &RequestError{Message: "Unknown profile", Details: "Available profiles: " + strings.Join(profileNames(), ", ")}
+	}
+
+	if machine, ok := registry.lookup(table); ok {
+		if profile, ok := HEADER_PROFILES[machine.Profile]; ok {
+			return profile, nil
+		}
+	}
+	return DEFAULT_PROFILE, nil
+}
+
+// Get the names of all profiles, sorted
+func profileNames() []string {
+	names := []string{DEFAULT_PROFILE.Name}
+	for name := range HEADER_PROFILES {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Get the display label for a column key
+func (p *HeaderProfile) label(key string) string {
+	label := p.Labels[key]
+	if label == "" {
+		label = PRETTY_HEADER_MAP[key]
+	}
+	if label == "" {
+		// Aggregated statistics such as LP_value__avg become "LP Value (avg)"
+		if column, stat, ok := strings.Cut(key, "__"); ok {
+			return p.label(column) + " (" + stat + ")"
+		}
+		label = key
+	}
+
+	if unit := p.Units[key]; unit != "" {
+		label += " (" + unit + ")"
+	}
+	return label
+}
+
+// Get a copy of the profile with a different unit for one column
+func (p *HeaderProfile) withUnit(key, unit string) *HeaderProfile {
+	units := make(map[string]string, len(p.Units)+1)
+	for k, v := range p.Units {
+		units[k] = v
+	}
+	units[key] = unit
+
+	profile := *p
+	profile.Units = units
+	return &profile
+}
+
+// Get a copy of the profile with extra labels
+func (p *HeaderProfile) withLabels(extra map[string]string) *HeaderProfile {
+	labels := make(map[string]string, len(p.Labels)+len(extra))
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+
+	profile := *p
+	profile.Labels = labels
+	return &profile
+}
+
+// Get the preferred numeric column order
+func (p *HeaderProfile) columnOrder() []string {
+	if p == nil || len(p.Order) == 0 {
+		return PREFERRED_NUMERIC_ORDER
+	}
+	return p.Order
+}
+
+// Get the charts drawn for charts=true
+func (p *HeaderProfile) charts() []ChartSpec {
+	if p == nil || len(p.Charts) == 0 {
+		return DEFAULT_CHARTS
+	}
+	return p.Charts
+}
+
+// Get the set-point ranges highlighted in XLSX exports
+func (p *HeaderProfile) limits() map[string]ColumnLimit {
+	if p == nil || len(p.Limits) == 0 {
+		return DEFAULT_LIMITS
+	}
+	return p.Limits
+}
+
+// Get the unit of a column key from the profile or the built-in label, e.g.
+// "°C" for "T1 Mean Temp (°C)". Statistics and per-machine keys take the
+// unit of their column.
+func (p *HeaderProfile) unit(key string) string {
+	column, _, _ := strings.Cut(key, "__")
+	column, _, _ = strings.Cut(column, "@")
+	if unit := p.Units[column]; unit != "" {
+		return unit
+	}
+
+	label := p.Labels[column]
+	if label == "" {
+		label = PRETTY_HEADER_MAP[column]
+	}
+	if i := strings.LastIndex(label, " ("); i >= 0 && strings.HasSuffix(label, ")") {
+		return label[i+2 : len(label)-1]
+	}
+	return ""
+}
+
+// Check if a column is hidden by the profile
+func (p *HeaderProfile) hides(key string) bool {
+	if p == nil {
+		return false
+	}
+	for _, hidden := range p.Hidden {
+		if hidden == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeaderProfileLabel(t *testing.T) {
+	profile := &HeaderProfile{
+		Labels: map[string]string{"AHT_vale_speed": "AHT Valve Speed", "Compressor_timer": "Compressor Timer"},
+		Units:  map[string]string{"AHT_vale_speed": "%", "Compressor_timer": "s"},
+	}
+
+	tests := []struct {
+		profile  *HeaderProfile
+		key      string
+		expected string
+	}{
+		{DEFAULT_PROFILE, "LP_value", "LP Value"},
+		{DEFAULT_PROFILE, "unknown_column", "unknown_column"},
+		{profile, "AHT_vale_speed", "AHT Valve Speed (%)"},
+		{profile, "Compressor_timer__max", "Compressor Timer (s) (max)"},
+		{profile, "LP_value", "LP Value"},
+	}
+
+	for _, test := range tests {
+		result := test.profile.label(test.key)
+		if result != test.expected {
+			t.Errorf("label(%s) = %q, expected %q", test.key, result, test.expected)
+		}
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	s7 := &HeaderProfile{Name: "S7_200"}
+	HEADER_PROFILES = map[string]*HeaderProfile{"S7_200": s7}
+	registry.set([]Machine{{Table: "GTPL_108_gT_40E_P_S7_200_Germany", Profile: "S7_200"}, {Table: "GTPL_121_GT1000T"}})
+	defer func() {
+		HEADER_PROFILES = map[string]*HeaderProfile{}
+		registry.set(nil)
+	}()
+
+	tests := []struct {
+		name     string
+		table    string
+		expected *HeaderProfile
+	}{
+		{"", "GTPL_108_gT_40E_P_S7_200_Germany", s7},
+		{"", "GTPL_121_GT1000T", DEFAULT_PROFILE},
+		{"default", "GTPL_108_gT_40E_P_S7_200_Germany", DEFAULT_PROFILE},
+		{"S7_200", "GTPL_121_GT1000T", s7},
+	}
+
+	for _, test := range tests {
+		result, err := resolveProfile(test.name, test.table)
+		if err != nil || result != test.expected {
+			t.Errorf("resolveProfile(%q, %s) = %v, %v, expected %v", test.name, test.table, result, err, test.expected)
+		}
+	}
+
+	if _, err := resolveProfile("missing", "GTPL_121_GT1000T"); err == nil {
+		t.Errorf("resolveProfile(missing) returned no error")
+	}
+}
+
+func TestBuildColumnSelectionHidden(t *testing.T) {
+	tableColumns := []string{"id", "created_at", "LP_value", "AHT_vale_speed"}
+	profile := &HeaderProfile{Hidden: []string{"AHT_vale_speed"}}
+
+	tests := []struct {
+		include        []string
+		pretty         bool
+		expectedOutput bool
+	}{
+		{nil, true, false},
+		{[]string{"AHT_vale_speed"}, true, true},
+		{nil, false, true},
+	}
+
+	for _, test := range tests {
+		result, err := buildColumnSelection(tableColumns, test.include, nil, test.pretty, profile)
+		if err != nil {
+			t.Fatalf("buildColumnSelection(%v) error: %v", test.include, err)
+		}
+		output := result == nil || result.Output["AHT_vale_speed"]
+		if output != test.expectedOutput {
+			t.Errorf("buildColumnSelection(%v, pretty=%v) outputs AHT_vale_speed = %v, expected %v", test.include, test.pretty, output, test.expectedOutput)
+		}
+	}
+}
+
+func TestOrderNumericColumnsProfile(t *testing.T) {
+	profile := &HeaderProfile{Order: []string{"HP_value", "LP_value"}}
+	result := orderNumericColumns([]string{"LP_value", "extra", "HP_value"}, profile.columnOrder())
+	expected := []string{"HP_value", "LP_value", "extra"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("orderNumericColumns() = %v, expected %v", result, expected)
+	}
+}
+
+func TestHeaderProfileCharts(t *testing.T) {
+	custom := []ChartSpec{{Title: "Outputs", Columns: []string{"BLWR_pct"}}}
+
+	tests := []struct {
+		profile  *HeaderProfile
+		expected []ChartSpec
+	}{
+		{nil, DEFAULT_CHARTS},
+		{DEFAULT_PROFILE, DEFAULT_CHARTS},
+		{&HeaderProfile{Charts: custom}, custom},
+	}
+
+	for _, test := range tests {
+		if result := test.profile.charts(); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("charts() = %v, expected %v", result, test.expected)
+		}
+	}
+}
+
+func TestHeaderProfileUnit(t *testing.T) {
+	profile := &HeaderProfile{Units: map[string]string{"Compressor_timer": "s", "LP_value": "bar"}}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"T1_temp_mean", "°C"},
+		{"T1_temp_mean__avg", "°C"},
+		{"Blower_speed@GTPL_110", "%"},
+		{"LP_value", "bar"},
+		{"Compressor_timer__max", "s"},
+		{"HP_set_point", ""},
+	}
+
+	for _, test := range tests {
+		if result := profile.unit(test.key); result != test.expected {
+			t.Errorf("unit(%s) = %q, expected %q", test.key, result, test.expected)
+		}
+	}
+}
@@ -39,7 +39,7 @@ if [ $? -ne 0 ]; then
 fi
 
 echo "🔨 Building application..."
-go build -o export-api main.go
+go build -o export-api .
 
 if [ $? -ne 0 ]; then
     echo "❌ Build failed"
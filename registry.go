@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// How often the registry file is checked for changes
+const REGISTRY_POLL_INTERVAL = 30 * time.Second
+
+// Table names are interpolated into SQL, so only plain identifiers are accepted
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Characters replaced when a machine name is used in a filename
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Machine is a registered dryer and the table its PLC logs into
+type Machine struct {
+	Table   string `json:"table" yaml:"table"`
+	Name    string `json:"name,omitempty" yaml:"name"`
+	Site    string `json:"site,omitempty" yaml:"site"`
+	PLCType string `json:"plcType,omitempty" yaml:"plcType"`
+	Model   string `json:"model,omitempty" yaml:"model"`
+	Profile string `json:"profile,omitempty" yaml:"profile"`
+	// IANA zone created_at is stored in, STORAGE_TIMEZONE if empty
+	Timezone string `json:"timezone,omitempty" yaml:"timezone"`
+}
+
+// machineRegistry holds the machines that may be exported
+type machineRegistry struct {
+	mu       sync.RWMutex
+	machines []Machine
+	byTable  map[string]Machine
+
+	path    string
+	dbTable string
+	modTime time.Time
+}
+
+var registry = &machineRegistry{byTable: map[string]Machine{}}
+
+// Load the machine registry and keep it up to date. Machines come from
+// MACHINE_REGISTRY_FILE and, when MACHINE_REGISTRY_TABLE is set, from a
+// MySQL metadata table whose entries override the file.
+func initMachineRegistry() {
+	registry.path = os.Getenv("MACHINE_REGISTRY_FILE")
+	if registry.path == "" {
+		registry.path = "config/machines.json"
+	}
+	registry.dbTable = os.Getenv("MACHINE_REGISTRY_TABLE")
+	if registry.dbTable != "" && !tableNamePattern.MatchString(registry.dbTable) {
+		log.Fatalf("Invalid MACHINE_REGISTRY_TABLE: %q", registry.dbTable)
+	}
+
+	// Start empty rather than exit, so the registry can be fixed and reloaded
+	if err := registry.reload(true); err != nil {
+		log.Printf("Machine registry not loaded, no tables are exportable: %v", err)
+	}
+
+	// Reload on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Printf("SIGHUP received, reloading machine registry")
+			if err := registry.reload(true); err != nil {
+				log.Printf("Machine registry reload failed, keeping previous entries: %v", err)
+			}
+		}
+	}()
+
+	// Reload when the file changes, and periodically pick up database entries.
+	// Polls only log when the machines changed.
+	go func() {
+		for range time.Tick(REGISTRY_POLL_INTERVAL) {
+			if !registry.fileChanged() && registry.dbTable == "" {
+				continue
+			}
+			if err := registry.reload(false); err != nil {
+				log.Printf("Machine registry reload failed, keeping previous entries: %v", err)
+			}
+		}
+	}()
+}
+
+// Load machines from all sources and swap them in. The result is logged when
+// announce is set or the machines changed.
+func (r *machineRegistry) reload(announce bool) error {
+	machines, modTime, err := loadMachineFile(r.path)
+	fileMissing := err != nil
+	if err != nil {
+		// The metadata table alone is enough when there is no file
+		if !os.IsNotExist(err) || r.dbTable == "" {
+			return err
+		}
+	}
+
+	if r.dbTable != "" {
+		dbMachines, err := loadMachineTable(context.Background(), r.dbTable)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", r.dbTable, err)
+		}
+		machines = mergeMachines(machines, dbMachines)
+	}
+
+	changed := r.set(machines)
+
+	r.mu.Lock()
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	if announce || changed {
+		if fileMissing {
+			log.Printf("Machine registry file %s not found, reading %s only", r.path, r.dbTable)
+		}
+		log.Printf("Machine registry loaded: %d machines", len(machines))
+	}
+	return nil
+}
+
+// Replace the registered machines, reporting whether they changed
+func (r *machineRegistry) set(machines []Machine) bool {
+	byTable := make(map[string]Machine, len(machines))
+	for _, machine := range machines {
+		byTable[machine.Table] = machine
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := len(machines) != len(r.machines)
+	for i := 0; !changed && i < len(machines); i++ {
+		changed = machines[i] != r.machines[i]
+	}
+	r.machines = machines
+	r.byTable = byTable
+	return changed
+}
+
+// Check if the registry file was modified since the last load
+func (r *machineRegistry) fileChanged() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+// Get a registered machine by table name
+func (r *machineRegistry) lookup(table string) (Machine, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	machine, ok := r.byTable[table]
+	return machine, ok
+}
+
+// Get all registered machines
+func (r *machineRegistry) list() []Machine {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	machines := make([]Machine, len(r.machines))
+	copy(machines, r.machines)
+	return machines
+}
+
+// Get all registered table names
+func (r *machineRegistry) tables() []string {
+	machines := r.list()
+	tables := make([]string, len(machines))
+	for i, machine := range machines {
+		tables[i] = machine.Table
+	}
+	return tables
+}
+
+// Get the machines at a site, in registry order
+func (r *machineRegistry) site(site string) []Machine {
+	var machines []Machine
+	for _, machine := range r.list() {
+		if strings.EqualFold(machine.Site, site) {
+			machines = append(machines, machine)
+		}
+	}
+	return machines
+}
+
+// Read machines from a JSON or YAML registry file
+func loadMachineFile(path string) ([]Machine, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var file struct {
+		Machines []Machine `json:"machines" yaml:"machines"`
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	machines, err := validateMachines(file.Machines)
+	return machines, info.ModTime(), err
+}
+
+// Read machines from a metadata table with columns
+// table_name, name, site, plc_type, model, profile and timezone
+func loadMachineTable(ctx context.Context, table string) ([]Machine, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT table_name, COALESCE(name, ''), COALESCE(site, ''), COALESCE(plc_type, ''), COALESCE(model, ''), COALESCE(profile, ''), COALESCE(timezone, '') FROM `%s`", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var machines []Machine
+	for rows.Next() {
+		var machine Machine
+		if err := rows.Scan(&machine.Table, &machine.Name, &machine.Site, &machine.PLCType, &machine.Model, &machine.Profile, &machine.Timezone); err != nil {
+			return nil, err
+		}
+		machines = append(machines, machine)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return validateMachines(machines)
+}
+
+// Reject entries without a usable table name or with an unknown timezone
+func validateMachines(machines []Machine) ([]Machine, error) {
+	seen := make(map[string]bool, len(machines))
+	for _, machine := range machines {
+		if !tableNamePattern.MatchString(machine.Table) {
+			return nil, fmt.Errorf("invalid table name %q", machine.Table)
+		}
+		if _, err := time.LoadLocation(machine.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q for %s: %v", machine.Timezone, machine.Table, err)
+		}
+		if seen[machine.Table] {
+			return nil, fmt.Errorf("duplicate table %q", machine.Table)
+		}
+		seen[machine.Table] = true
+	}
+	return machines, nil
+}
+
+// Merge two machine lists; entries in override replace those with the same table
+func mergeMachines(base, override []Machine) []Machine {
+	byTable := make(map[string]int, len(base))
+	merged := make([]Machine, len(base))
+	copy(merged, base)
+	for i, machine := range merged {
+		byTable[machine.Table] = i
+	}
+
+	for _, machine := range override {
+		if i, ok := byTable[machine.Table]; ok {
+			merged[i] = machine
+			continue
+		}
+		byTable[machine.Table] = len(merged)
+		merged = append(merged, machine)
+	}
+	return merged
+}
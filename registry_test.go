@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMachineFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"machines.json", `{"machines": [{"table": "GTPL_121_GT1000T", "name": "GTPL 121", "site": "Pune", "plcType": "GT1000T"}]}`},
+		{"machines.yaml", "machines:\n  - table: GTPL_121_GT1000T\n    name: GTPL 121\n    site: Pune\n    plcType: GT1000T\n"},
+	}
+
+	expected := Machine{Table: "GTPL_121_GT1000T", Name: "GTPL 121", Site: "Pune", PLCType: "GT1000T"}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		machines, _, err := loadMachineFile(path)
+		if err != nil {
+			t.Errorf("loadMachineFile(%s) error: %v", test.name, err)
+			continue
+		}
+		if len(machines) != 1 || machines[0] != expected {
+			t.Errorf("loadMachineFile(%s) = %v, expected [%v]", test.name, machines, expected)
+		}
+	}
+}
+
+func TestRegistryReloadMissingFile(t *testing.T) {
+	r := &machineRegistry{byTable: map[string]Machine{}, path: filepath.Join(t.TempDir(), "machines.json")}
+	if err := r.reload(true); err == nil {
+		t.Errorf("reload() of a missing file succeeded, expected an error")
+	}
+	if tables := r.tables(); len(tables) != 0 {
+		t.Errorf("tables() = %v, expected an empty registry", tables)
+	}
+
+	if err := os.WriteFile(r.path, []byte(`{"machines": [{"table": "GTPL_121_GT1000T"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !r.fileChanged() {
+		t.Errorf("fileChanged() = false after the file was created, expected true")
+	}
+	if err := r.reload(true); err != nil {
+		t.Errorf("reload() error: %v", err)
+	}
+	if _, ok := r.lookup("GTPL_121_GT1000T"); !ok {
+		t.Errorf("lookup(GTPL_121_GT1000T) = false after reload, expected true")
+	}
+}
+
+func TestRegistrySetChanged(t *testing.T) {
+	r := &machineRegistry{byTable: map[string]Machine{}}
+	machines := []Machine{{Table: "GTPL_110", Name: "GTPL 110"}, {Table: "GTPL_111"}}
+
+	tests := []struct {
+		machines []Machine
+		expected bool
+	}{
+		{machines, true},
+		{[]Machine{{Table: "GTPL_110", Name: "GTPL 110"}, {Table: "GTPL_111"}}, false},
+		{[]Machine{{Table: "GTPL_110", Name: "GTPL 110", Site: "Germany"}, {Table: "GTPL_111"}}, true},
+		{nil, true},
+		{[]Machine{}, false},
+	}
+
+	for i, test := range tests {
+		if result := r.set(test.machines); result != test.expected {
+			t.Errorf("set() #%d = %v, expected %v", i, result, test.expected)
+		}
+	}
+}
+
+func TestValidateMachines(t *testing.T) {
+	tests := []struct {
+		machines []Machine
+		valid    bool
+	}{
+		{[]Machine{{Table: "GTPL_108_gT_40E_P_S7_200_Germany"}, {Table: "gtpl_122_s7_1200_01"}}, true},
+		{[]Machine{{Table: ""}}, false},
+		{[]Machine{{Table: "GTPL_108`; DROP TABLE x"}}, false},
+		{[]Machine{{Table: "GTPL_121_GT1000T"}, {Table: "GTPL_121_GT1000T"}}, false},
+	}
+
+	for _, test := range tests {
+		_, err := validateMachines(test.machines)
+		if (err == nil) != test.valid {
+			t.Errorf("validateMachines(%v) error = %v, expected valid %v", test.machines, err, test.valid)
+		}
+	}
+}
+
+func TestMergeMachines(t *testing.T) {
+	base := []Machine{{Table: "a", Name: "A"}, {Table: "b", Name: "B"}}
+	override := []Machine{{Table: "b", Name: "B2", Site: "Plant 2"}, {Table: "c", Name: "C"}}
+
+	merged := mergeMachines(base, override)
+	expected := []Machine{{Table: "a", Name: "A"}, {Table: "b", Name: "B2", Site: "Plant 2"}, {Table: "c", Name: "C"}}
+	if len(merged) != len(expected) {
+		t.Fatalf("mergeMachines() = %v, expected %v", merged, expected)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Errorf("mergeMachines()[%d] = %v, expected %v", i, merged[i], expected[i])
+		}
+	}
+	if base[1].Name != "B" {
+		t.Errorf("mergeMachines() modified base: %v", base)
+	}
+}
+
+func TestExportFilenameMachineName(t *testing.T) {
+	registry.set([]Machine{
+		{Table: "GTPL_108_gT_40E_P_S7_200_Germany", Name: "GTPL 108 / Line A"},
+		{Table: "kabomachinedatasmart200"},
+	})
+	defer registry.set(nil)
+
+	tests := []struct {
+		table    string
+		expected string
+	}{
+		{"GTPL_108_gT_40E_P_S7_200_Germany", "GTPL_108_Line_A_"},
+		{"kabomachinedatasmart200", "kabomachinedatasmart200_"},
+		{"unregistered", "unregistered_"},
+	}
+
+	for _, test := range tests {
+		result := exportFilename(test.table, 5, "csv")
+		if !strings.HasPrefix(result, test.expected) || !strings.HasSuffix(result, "_5records.csv") {
+			t.Errorf("exportFilename(%s) = %v, expected prefix %v", test.table, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ColumnSchema describes how an export treats a table column
+type ColumnSchema struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Label    string `json:"label"`
+	Numeric  bool   `json:"numeric"`
+	Fault    bool   `json:"fault"`
+}
+
+// Describe a table column
+func describeColumn(column TableColumn, profile *HeaderProfile) ColumnSchema {
+	return ColumnSchema{
+		Name:     column.Name,
+		Type:     column.ColumnType,
+		Nullable: column.Nullable,
+		Label:    headerLabel(column.Name, profile),
+		Numeric:  column.isNumeric(),
+		Fault:    looksLikeFaultKey(column.Name),
+	}
+}
+
+// Handle table schema request
+func handleTableSchema(c *gin.Context) {
+	table := c.Param("table")
+	if !isTableAllowed(table) {
+		c.JSON(http.StatusBadRequest, ExportResponse{Error: "Invalid table name"})
+		return
+	}
+
+	profile, err := resolveProfile(c.Query("profile"), table)
+	if err != nil {
+		respondRequestError(c, err)
+		return
+	}
+
+	zones, err := resolveZones(c.Query("tz"), table)
+	if err != nil {
+		respondRequestError(c, err)
+		return
+	}
+	labels := profile.withUnit("created_at", zones.label())
+
+	tableColumns, err := getTableColumns(c.Request.Context(), table)
+	if err != nil {
+		log.Printf("Error reading schema of %s: %v", table, err)
+		c.JSON(http.StatusInternalServerError, ExportResponse{Error: "Failed to read table schema"})
+		return
+	}
+
+	columns := make([]ColumnSchema, len(tableColumns))
+	for i, column := range tableColumns {
+		columns[i] = describeColumn(column, labels)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table":     table,
+		"profile":   profile.Name,
+		"timezone":  zones.Display.String(),
+		"columns":   columns,
+		"count":     len(columns),
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
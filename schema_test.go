@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestDescribeColumn(t *testing.T) {
+	tests := []struct {
+		column   TableColumn
+		expected ColumnSchema
+	}{
+		{
+			TableColumn{Name: "T1_temp_mean", DataType: "double", ColumnType: "double", Nullable: true},
+			ColumnSchema{Name: "T1_temp_mean", Type: "double", Nullable: true, Label: "T1 Mean Temp (°C)", Numeric: true},
+		},
+		{
+			TableColumn{Name: "LP_value", DataType: "decimal", ColumnType: "decimal(10,2)"},
+			ColumnSchema{Name: "LP_value", Type: "decimal(10,2)", Label: PRETTY_HEADER_MAP["LP_value"], Numeric: true},
+		},
+		{
+			TableColumn{Name: "door_open", DataType: "tinyint", ColumnType: "tinyint(1)"},
+			ColumnSchema{Name: "door_open", Type: "tinyint(1)", Label: "door_open", Numeric: true, Fault: true},
+		},
+		{
+			TableColumn{Name: "created_at", DataType: "datetime", ColumnType: "datetime"},
+			ColumnSchema{Name: "created_at", Type: "datetime", Label: PRETTY_HEADER_MAP["created_at"]},
+		},
+	}
+
+	for _, test := range tests {
+		result := describeColumn(test.column, DEFAULT_PROFILE)
+		if result != test.expected {
+			t.Errorf("describeColumn(%v) = %v, expected %v", test.column, result, test.expected)
+		}
+	}
+}
@@ -33,7 +33,7 @@ go mod verify
 
 # Build the application
 echo "Building application..."
-go build -o bin/export-api main.go
+go build -o bin/export-api .
 
 if [ $? -eq 0 ]; then
     echo "✓ Build successful! Binary created at bin/export-api"
@@ -50,7 +50,7 @@ if [ $? -eq 0 ]; then
     echo "   ./bin/export-api"
     echo ""
     echo "3. Or run directly with:"
-    echo "   go run main.go"
+    echo "   go run ."
     echo ""
     echo "4. Test the API:"
     echo "   curl http://localhost:8080/health"
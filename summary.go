@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Sheet name for the summary statistics
+const SUMMARY_SHEET = "Summary"
+
+// columnStats accumulates the statistics of one numeric column. Times are
+// created_at values as exported, so first and last follow created_at rather
+// than the export order.
+type columnStats struct {
+	Count   int
+	Min     float64
+	MinAt   string
+	Max     float64
+	MaxAt   string
+	First   float64
+	FirstAt string
+	Last    float64
+	LastAt  string
+	Mean    float64
+
+	// Sum of squared deviations from the running mean (Welford's algorithm)
+	m2 float64
+}
+
+// Add a value seen at a created_at time
+func (s *columnStats) add(v float64, at string) {
+	if s.Count == 0 {
+		s.Min, s.MinAt, s.Max, s.MaxAt = v, at, v, at
+		s.First, s.FirstAt, s.Last, s.LastAt = v, at, v, at
+	}
+	s.Count++
+
+	// Extremes report their earliest occurrence
+	if v < s.Min || (v == s.Min && at < s.MinAt) {
+		s.Min, s.MinAt = v, at
+	}
+	if v > s.Max || (v == s.Max && at < s.MaxAt) {
+		s.Max, s.MaxAt = v, at
+	}
+	if at < s.FirstAt {
+		s.First, s.FirstAt = v, at
+	}
+	if at >= s.LastAt {
+		s.Last, s.LastAt = v, at
+	}
+
+	delta := v - s.Mean
+	s.Mean += delta / float64(s.Count)
+	s.m2 += delta * (v - s.Mean)
+}
+
+// Get the sample standard deviation, 0 for fewer than two values
+func (s *columnStats) stdDev() float64 {
+	if s.Count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.Count-1))
+}
+
+// exportSummary accumulates statistics of an export's rows while they are
+// streamed, so the Summary sheet needs no second pass over the data
+type exportSummary struct {
+	headers    []string
+	rows       int
+	from       string
+	to         string
+	columns    map[string]*columnStats
+	faultRows  int
+	faultCount map[string]int
+}
+
+// Create a summary for rows with the given output headers
+func newExportSummary(headers []string) *exportSummary {
+	return &exportSummary{headers: headers, columns: make(map[string]*columnStats), faultCount: make(map[string]int)}
+}
+
+// Record one exported row
+func (s *exportSummary) observe(row DataRow) {
+	s.rows++
+
+	at := formatTextValue(row["created_at"])
+	if at != "" {
+		if s.from == "" || at < s.from {
+			s.from = at
+		}
+		if at > s.to {
+			s.to = at
+		}
+	}
+
+	for _, key := range s.headers {
+		if !summaryColumn(key) {
+			continue
+		}
+		f, ok := toNum(aggregateValue(row[key])).(float64)
+		if !ok {
+			continue
+		}
+
+		stats := s.columns[key]
+		if stats == nil {
+			stats = &columnStats{}
+			s.columns[key] = stats
+		}
+		stats.add(f, at)
+	}
+
+	// Pretty rows carry the faults already extracted
+	faults, ok := row["Faults"].(string)
+	if !ok {
+		faults = extractFaults(row)
+	}
+	if faults != "" {
+		s.faultRows++
+		for _, fault := range strings.Split(faults, ", ") {
+			s.faultCount[fault]++
+		}
+	}
+}
+
+// Check if a column gets statistics; ids, timestamps and fault flags do not
+func summaryColumn(key string) bool {
+	switch key {
+	case "id", "created_at", "created_at_date", "created_at_time", "Faults":
+		return false
+	}
+	return !looksLikeFaultKey(key)
+}
+
+// Build the Summary sheet: export totals, then statistics per numeric column
+// in header order, then row counts per fault ordered by count
+func (s *exportSummary) sheet(labels *HeaderProfile, zone string) [][]interface{} {
+	rows := [][]interface{}{
+		{"Total rows", s.rows},
+		{"From (" + zone + ")", s.from},
+		{"To (" + zone + ")", s.to},
+		{"Rows with faults", s.faultRows},
+		{},
+		{"Column", "Count", "Min", "Min At", "Max", "Max At", "Mean", "Std Dev", "First", "First At", "Last", "Last At"},
+	}
+
+	for _, key := range s.headers {
+		stats := s.columns[key]
+		if stats == nil {
+			continue
+		}
+		rows = append(rows, []interface{}{
+			headerLabel(key, labels), stats.Count,
+			stats.Min, stats.MinAt, stats.Max, stats.MaxAt,
+			stats.Mean, stats.stdDev(),
+			stats.First, stats.FirstAt, stats.Last, stats.LastAt,
+		})
+	}
+
+	faults := make([]string, 0, len(s.faultCount))
+	for fault := range s.faultCount {
+		faults = append(faults, fault)
+	}
+	sort.Slice(faults, func(i, j int) bool {
+		if s.faultCount[faults[i]] == s.faultCount[faults[j]] {
+			return faults[i] < faults[j]
+		}
+		return s.faultCount[faults[i]] > s.faultCount[faults[j]]
+	})
+
+	rows = append(rows, []interface{}{}, []interface{}{"Fault", "Rows"})
+	for _, fault := range faults {
+		rows = append(rows, []interface{}{fault, s.faultCount[fault]})
+	}
+	return rows
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestColumnStats(t *testing.T) {
+	// Rows arrive newest first, as in order=desc exports
+	values := []struct {
+		v  float64
+		at string
+	}{
+		{4, "2024-07-01 10:03:00"},
+		{9, "2024-07-01 10:02:00"},
+		{2, "2024-07-01 10:01:00"},
+		{9, "2024-07-01 10:00:00"},
+	}
+
+	var stats columnStats
+	for _, value := range values {
+		stats.add(value.v, value.at)
+	}
+
+	tests := []struct {
+		name     string
+		result   interface{}
+		expected interface{}
+	}{
+		{"Count", stats.Count, 4},
+		{"Min", stats.Min, 2.0},
+		{"MinAt", stats.MinAt, "2024-07-01 10:01:00"},
+		{"Max", stats.Max, 9.0},
+		{"MaxAt", stats.MaxAt, "2024-07-01 10:00:00"},
+		{"First", stats.First, 9.0},
+		{"FirstAt", stats.FirstAt, "2024-07-01 10:00:00"},
+		{"Last", stats.Last, 4.0},
+		{"LastAt", stats.LastAt, "2024-07-01 10:03:00"},
+		{"Mean", stats.Mean, 6.0},
+	}
+
+	for _, test := range tests {
+		if test.result != test.expected {
+			t.Errorf("columnStats.%s = %v, expected %v", test.name, test.result, test.expected)
+		}
+	}
+
+	if result := stats.stdDev(); math.Abs(result-math.Sqrt(38.0/3)) > 1e-9 {
+		t.Errorf("columnStats.stdDev() = %v, expected %v", result, math.Sqrt(38.0/3))
+	}
+}
+
+func TestExportSummary(t *testing.T) {
+	summary := newExportSummary([]string{"id", "created_at", "LP_value", "HP_value", "Faults"})
+	rows := []DataRow{
+		{"id": int64(3), "created_at": "2024-07-01 10:02:00", "LP_value": 4.5, "HP_value": "", "Faults": "Door open fault, HP fault"},
+		{"id": int64(2), "created_at": "2024-07-01 10:01:00", "LP_value": 3.5, "HP_value": 18.0, "Faults": "HP fault"},
+		{"id": int64(1), "created_at": "2024-07-01 10:00:00", "LP_value": 4.0, "HP_value": 17.0, "Faults": ""},
+	}
+	for _, row := range rows {
+		summary.observe(row)
+	}
+
+	sheet := summary.sheet(DEFAULT_PROFILE, "IST")
+	expected := [][]interface{}{
+		{"Total rows", 3},
+		{"From (IST)", "2024-07-01 10:00:00"},
+		{"To (IST)", "2024-07-01 10:02:00"},
+		{"Rows with faults", 2},
+		{},
+		{"Column", "Count", "Min", "Min At", "Max", "Max At", "Mean", "Std Dev", "First", "First At", "Last", "Last At"},
+		{"LP Value", 3, 3.5, "2024-07-01 10:01:00", 4.5, "2024-07-01 10:02:00", 4.0, 0.5, 4.0, "2024-07-01 10:00:00", 4.5, "2024-07-01 10:02:00"},
+		{"HP Value", 2, 17.0, "2024-07-01 10:00:00", 18.0, "2024-07-01 10:01:00", 17.5, math.Sqrt(0.5), 17.0, "2024-07-01 10:00:00", 18.0, "2024-07-01 10:01:00"},
+		{},
+		{"Fault", "Rows"},
+		{"HP fault", 2},
+		{"Door open fault", 1},
+	}
+	if !reflect.DeepEqual(sheet, expected) {
+		t.Errorf("sheet() = %v, expected %v", sheet, expected)
+	}
+}
+
+func TestExportSummaryRawRows(t *testing.T) {
+	// Raw rows keep driver values and their fault columns
+	summary := newExportSummary([]string{"id", "created_at", "LP_value", "Door_open_fault"})
+	summary.observe(DataRow{"id": int64(1), "created_at": "2024-07-01 10:00:00", "LP_value": []byte("4.25"), "Door_open_fault": int64(1)})
+	summary.observe(DataRow{"id": int64(2), "created_at": "2024-07-01 10:01:00", "LP_value": float32(4.75), "Door_open_fault": int64(0)})
+
+	stats := summary.columns["LP_value"]
+	if stats == nil || stats.Count != 2 || stats.Mean != 4.5 {
+		t.Errorf("columns[LP_value] = %+v, expected 2 values with mean 4.5", stats)
+	}
+	if _, ok := summary.columns["Door_open_fault"]; ok {
+		t.Errorf("columns[Door_open_fault] is set, expected fault flags to be counted only")
+	}
+	if summary.faultRows != 1 || summary.faultCount["Door open fault"] != 1 {
+		t.Errorf("fault counts = %d, %v, expected 1 row of Door open fault", summary.faultRows, summary.faultCount)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Accepted layouts for fromTime and toTime besides RFC3339. They carry no
+// offset and are read in the display zone.
+var TIMESTAMP_LAYOUTS = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+}
+
+// An RFC3339 timestamp whose "+" offset sign was decoded from the query
+// string as a space, as in fromTime=2024-07-01T10:00:00+05:30
+var spacedOffsetPattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T[0-9:.]+) (\d{2}:\d{2})$`)
+
+// timeRange is the half-open created_at range [From, To) of an export; nil
+// bounds are open
+type timeRange struct {
+	From *time.Time
+	To   *time.Time
+}
+
+// Resolve fromDate/toDate, fromTime/toTime or last= into a time range.
+// Dates are whole days and times exact instants, both in the display zone;
+// last= ends at now.
+func parseTimeRange(req ExportRequest, zones exportZones, now time.Time) (timeRange, error) {
+	var r timeRange
+
+	if req.Last != "" {
+		if req.FromDate != "" || req.ToDate != "" || req.FromTime != "" || req.ToTime != "" {
+			return r, &RequestError{Message: "Conflicting range parameters", Details: "last cannot be combined with fromDate, toDate, fromTime or toTime"}
+		}
+		d, err := parseLastDuration(req.Last)
+		if err != nil {
+			return r, err
+		}
+		from := now.Add(-d)
+		r.From, r.To = &from, &now
+		return r, nil
+	}
+
+	if req.FromDate != "" && req.FromTime != "" {
+		return r, &RequestError{Message: "Conflicting range parameters", Details: "use either fromDate or fromTime"}
+	}
+	if req.ToDate != "" && req.ToTime != "" {
+		return r, &RequestError{Message: "Conflicting range parameters", Details: "use either toDate or toTime"}
+	}
+
+	if req.FromDate != "" {
+		from, err := parseDate("fromDate", req.FromDate, zones.Display)
+		if err != nil {
+			return r, err
+		}
+		r.From = &from
+	}
+	if req.FromTime != "" {
+		from, err := parseTimestamp("fromTime", req.FromTime, zones.Display)
+		if err != nil {
+			return r, err
+		}
+		r.From = &from
+	}
+
+	// toDate includes the whole day
+	if req.ToDate != "" {
+		to, err := parseDate("toDate", req.ToDate, zones.Display)
+		if err != nil {
+			return r, err
+		}
+		to = to.AddDate(0, 0, 1)
+		r.To = &to
+	}
+	if req.ToTime != "" {
+		to, err := parseTimestamp("toTime", req.ToTime, zones.Display)
+		if err != nil {
+			return r, err
+		}
+		r.To = &to
+	}
+
+	if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+		return r, &RequestError{Message: "Empty time range", Details: "the start must be before the end"}
+	}
+	return r, nil
+}
+
+// Parse a YYYY-MM-DD date as midnight in loc
+func parseDate(param, value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return t, &RequestError{Message: "Invalid " + param, Details: "Expected YYYY-MM-DD, got " + value}
+	}
+	return t, nil
+}
+
+// Parse an RFC3339 timestamp, or a timestamp without offset in loc
+func parseTimestamp(param, value string, loc *time.Location) (time.Time, error) {
+	value = spacedOffsetPattern.ReplaceAllString(value, "$1+$2")
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range TIMESTAMP_LAYOUTS {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &RequestError{Message: "Invalid " + param, Details: "Expected RFC3339 (2024-07-01T10:00:00+05:30) or YYYY-MM-DD HH:MM:SS, got " + value}
+}
+
+// Parse a last= duration such as 30m, 6h or 7d
+func parseLastDuration(value string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+
+	if err != nil || d <= 0 {
+		return 0, &RequestError{Message: "Invalid last", Details: "Expected a positive duration such as 30m, 6h or 7d, got " + value}
+	}
+	return d, nil
+}
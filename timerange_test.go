@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBuildWhereClauseRanges(t *testing.T) {
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		req            ExportRequest
+		zones          exportZones
+		expectedWhere  string
+		expectedParams []interface{}
+	}{
+		{ExportRequest{}, exportZones{ist, ist}, "", nil},
+		{ExportRequest{FromDate: "2024-07-01", ToDate: "2024-07-02"}, exportZones{ist, ist},
+			" WHERE created_at >= ? AND created_at < ?", []interface{}{"2024-07-01 00:00:00", "2024-07-03 00:00:00"}},
+		{ExportRequest{FromDate: "2024-07-01"}, exportZones{ist, berlin},
+			" WHERE created_at >= ?", []interface{}{"2024-07-01 03:30:00"}},
+		{ExportRequest{ToDate: "2024-01-01"}, exportZones{ist, time.UTC},
+			" WHERE created_at < ?", []interface{}{"2024-01-02 05:30:00"}},
+		{ExportRequest{FromTime: "2024-07-01 10:00:00", ToTime: "2024-07-01T10:20"}, exportZones{ist, ist},
+			" WHERE created_at >= ? AND created_at < ?", []interface{}{"2024-07-01 10:00:00", "2024-07-01 10:20:00"}},
+		{ExportRequest{FromTime: "2024-07-01T04:30:00.5Z", ToDate: "2024-07-01"}, exportZones{ist, berlin},
+			" WHERE created_at >= ? AND created_at < ?", []interface{}{"2024-07-01 10:00:00.5", "2024-07-02 03:30:00"}},
+		{ExportRequest{Last: "6h"}, exportZones{ist, ist},
+			" WHERE created_at >= ? AND created_at < ?", []interface{}{"2024-07-01 11:30:00", "2024-07-01 17:30:00"}},
+		{ExportRequest{Last: "2d"}, exportZones{time.UTC, time.UTC},
+			" WHERE created_at >= ? AND created_at < ?", []interface{}{"2024-06-29 12:00:00", "2024-07-01 12:00:00"}},
+	}
+
+	for _, test := range tests {
+		bounds, err := parseTimeRange(test.req, test.zones, now)
+		if err != nil {
+			t.Fatalf("parseTimeRange(%+v) error: %v", test.req, err)
+		}
+		where, params := buildWhereClause(bounds, test.zones)
+		if where != test.expectedWhere || !reflect.DeepEqual(params, test.expectedParams) {
+			t.Errorf("buildWhereClause(%+v) = %q, %v, expected %q, %v", test.req, where, params, test.expectedWhere, test.expectedParams)
+		}
+	}
+}
+
+func TestParseTimeRangeErrors(t *testing.T) {
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	zones := exportZones{ist, ist}
+
+	tests := []struct {
+		req      ExportRequest
+		expected string
+	}{
+		{ExportRequest{FromDate: "01/07/2024"}, "Invalid fromDate"},
+		{ExportRequest{ToDate: "2024-07-01 10:00"}, "Invalid toDate"},
+		{ExportRequest{FromTime: "yesterday"}, "Invalid fromTime"},
+		{ExportRequest{ToTime: "2024-07-01 25:00:00"}, "Invalid toTime"},
+		{ExportRequest{Last: "6"}, "Invalid last"},
+		{ExportRequest{Last: "-1h"}, "Invalid last"},
+		{ExportRequest{Last: "1h", FromDate: "2024-07-01"}, "Conflicting range parameters"},
+		{ExportRequest{FromDate: "2024-07-01", FromTime: "2024-07-01 10:00"}, "Conflicting range parameters"},
+		{ExportRequest{FromTime: "2024-07-01 10:20", ToTime: "2024-07-01 10:00"}, "Empty time range"},
+	}
+
+	for _, test := range tests {
+		_, err := parseTimeRange(test.req, zones, time.Now())
+		reqErr, ok := err.(*RequestError)
+		if !ok || reqErr.Message != test.expected {
+			t.Errorf("parseTimeRange(%+v) error = %v, expected %q", test.req, err, test.expected)
+		}
+	}
+}
+
+func TestParseTimestampQueryBinding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	expected := time.Date(2024, 7, 1, 4, 30, 0, 0, time.UTC)
+
+	// An unencoded "+" reaches the handler as a space
+	tests := []string{
+		"fromTime=2024-07-01T10:00:00+05:30",
+		"fromTime=2024-07-01T10:00:00%2B05:30",
+		"fromTime=2024-07-01T04:30:00Z",
+	}
+
+	for _, query := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/export?table=A&"+query, nil)
+
+		var req ExportRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			t.Fatalf("ShouldBindQuery(%s) error: %v", query, err)
+		}
+		result, err := parseTimestamp("fromTime", req.FromTime, time.UTC)
+		if err != nil || !result.Equal(expected) {
+			t.Errorf("parseTimestamp(%q) = %v, %v, expected %v", req.FromTime, result, err, expected)
+		}
+	}
+}
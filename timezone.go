@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // zone data for images without a system zoneinfo
+)
+
+// Zone created_at is stored in unless the registry or STORAGE_TIMEZONE say otherwise
+const DEFAULT_STORAGE_TIMEZONE = "Asia/Kolkata"
+
+// Storage zone for tables without one in the registry
+var STORAGE_ZONE, _ = time.LoadLocation(DEFAULT_STORAGE_TIMEZONE)
+
+// Zone pairs MySQL was found able to convert between with CONVERT_TZ
+var convertibleZones sync.Map
+
+// Load the default storage zone from STORAGE_TIMEZONE
+func loadStorageZone() {
+	name := os.Getenv("STORAGE_TIMEZONE")
+	if name == "" {
+		return
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_TIMEZONE %q: %v", name, err)
+	}
+	STORAGE_ZONE = loc
+}
+
+// exportZones describes how the created_at values of one export are read
+// and shown. created_at is a DATETIME holding wall-clock time in the
+// storage zone.
+type exportZones struct {
+	Storage *time.Location // zone the table's rows are written in
+	Display *time.Location // zone dates are filtered and shown in
+}
+
+// Resolve the zones for an export from tz= and the table's storage zone
+func resolveZones(tz, table string) (exportZones, error) {
+	storage := STORAGE_ZONE
+	if machine, ok := registry.lookup(table); ok && machine.Timezone != "" {
+		if loc, err := time.LoadLocation(machine.Timezone); err == nil {
+			storage = loc
+		}
+	}
+
+	zones := exportZones{Storage: storage, Display: storage}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return zones, &RequestError{Message: "Invalid timezone", Details: "Expected an IANA zone name such as Asia/Kolkata or Europe/Berlin, got " + tz}
+		}
+		zones.Display = loc
+	}
+	return zones, nil
+}
+
+// Convert a created_at value read by the driver into the display zone. The
+// driver reads DATETIME values as local time, so only the wall clock is kept.
+func (z exportZones) toDisplay(raw interface{}) interface{} {
+	t, ok := raw.(time.Time)
+	if !ok || z.Storage == nil || z.Display == nil {
+		return raw
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), z.Storage).In(z.Display)
+}
+
+// SQL expression for created_at as wall-clock time in the display zone.
+// Zones with a fixed offset, such as IST or UTC, are shifted by the
+// difference; zones with daylight saving time need MySQL's time zone tables
+// for CONVERT_TZ.
+func (z exportZones) displayCreatedAtExpr() string {
+	if z.Storage == nil || z.Display == nil || z.Storage.String() == z.Display.String() {
+		return "created_at"
+	}
+	if z.needsConvertTZ() {
+		return fmt.Sprintf("CONVERT_TZ(created_at, '%s', '%s')",
+			strings.ReplaceAll(z.Storage.String(), "'", "''"), strings.ReplaceAll(z.Display.String(), "'", "''"))
+	}
+
+	storageOffset, _ := fixedOffset(z.Storage)
+	displayOffset, _ := fixedOffset(z.Display)
+	if storageOffset == displayOffset {
+		return "created_at"
+	}
+	return fmt.Sprintf("DATE_ADD(created_at, INTERVAL %d SECOND)", displayOffset-storageOffset)
+}
+
+// Check if converting created_at to the display zone in SQL needs CONVERT_TZ
+func (z exportZones) needsConvertTZ() bool {
+	if z.Storage == nil || z.Display == nil || z.Storage.String() == z.Display.String() {
+		return false
+	}
+	_, storageFixed := fixedOffset(z.Storage)
+	_, displayFixed := fixedOffset(z.Display)
+	return !storageFixed || !displayFixed
+}
+
+// Check that MySQL can convert between the zones. Without its time zone
+// tables CONVERT_TZ returns NULL, which would put every row into one empty
+// bucket. A pair found convertible is not probed again.
+func (z exportZones) checkConvertTZ(ctx context.Context, q queryer) error {
+	if !z.needsConvertTZ() {
+		return nil
+	}
+	pair := z.Storage.String() + " " + z.Display.String()
+	if _, ok := convertibleZones.Load(pair); ok {
+		return nil
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT CONVERT_TZ('2000-01-01', ?, ?) IS NULL", z.Storage.String(), z.Display.String())
+	if err != nil {
+		return fmt.Errorf("error checking time zone support: %v", err)
+	}
+	defer rows.Close()
+
+	missing := true
+	if rows.Next() {
+		if err := rows.Scan(&missing); err != nil {
+			return fmt.Errorf("error checking time zone support: %v", err)
+		}
+	}
+	if missing {
+		return &RequestError{
+			Message: "Timezone not supported by the database",
+			Details: fmt.Sprintf("Converting %s to %s needs MySQL's time zone tables (mysql_tzinfo_to_sql); use a zone without daylight saving time or load them", z.Storage, z.Display),
+		}
+	}
+	convertibleZones.Store(pair, true)
+	return nil
+}
+
+// Get the zones for created_at values already converted to the display zone,
+// such as aggregation buckets
+func (z exportZones) displayed() exportZones {
+	return exportZones{Storage: z.Display, Display: z.Display}
+}
+
+// Get a zone's UTC offset in seconds, and whether it is the same all year
+func fixedOffset(loc *time.Location) (int, bool) {
+	year := time.Now().Year()
+	_, winter := time.Date(year, time.January, 1, 0, 0, 0, 0, loc).Zone()
+	_, summer := time.Date(year, time.July, 1, 0, 0, 0, 0, loc).Zone()
+	return winter, winter == summer
+}
+
+// Format a time as a created_at value for comparison in SQL
+func (z exportZones) storageValue(t time.Time) string {
+	return t.In(z.Storage).Format("2006-01-02 15:04:05.999999")
+}
+
+// Get the name shown next to created_at headers: the abbreviation for zones
+// without daylight saving time (e.g. "IST", "UTC"), else the IANA name
+func (z exportZones) label() string {
+	if z.Display == nil {
+		return ""
+	}
+
+	year := time.Now().Year()
+	winter, _ := time.Date(year, time.January, 1, 0, 0, 0, 0, z.Display).Zone()
+	summer, _ := time.Date(year, time.July, 1, 0, 0, 0, 0, z.Display).Zone()
+	if winter == summer && strings.Trim(winter, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		return winter
+	}
+	return z.Display.String()
+}
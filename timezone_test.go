@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestExportZonesToDisplay(t *testing.T) {
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	// The driver reads DATETIME values as local wall-clock time
+	stored := time.Date(2024, 7, 1, 12, 0, 0, 0, time.Local)
+	result := exportZones{ist, berlin}.toDisplay(stored).(time.Time)
+	if got := result.Format("2006-01-02 15:04:05 MST"); got != "2024-07-01 08:30:00 CEST" {
+		t.Errorf("toDisplay(%v) = %s, expected 2024-07-01 08:30:00 CEST", stored, got)
+	}
+
+	if result := (exportZones{ist, berlin}).toDisplay("2024-07-01 12:00:00"); result != "2024-07-01 12:00:00" {
+		t.Errorf("toDisplay(string) = %v, expected the value unchanged", result)
+	}
+}
+
+func TestDisplayCreatedAtExpr(t *testing.T) {
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	kathmandu, _ := time.LoadLocation("Asia/Kathmandu")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		zones    exportZones
+		expected string
+	}{
+		{exportZones{ist, ist}, "created_at"},
+		{exportZones{ist, time.UTC}, "DATE_ADD(created_at, INTERVAL -19800 SECOND)"},
+		{exportZones{time.UTC, ist}, "DATE_ADD(created_at, INTERVAL 19800 SECOND)"},
+		{exportZones{ist, kathmandu}, "DATE_ADD(created_at, INTERVAL 900 SECOND)"},
+		{exportZones{ist, berlin}, "CONVERT_TZ(created_at, 'Asia/Kolkata', 'Europe/Berlin')"},
+	}
+
+	for _, test := range tests {
+		if result := test.zones.displayCreatedAtExpr(); result != test.expected {
+			t.Errorf("displayCreatedAtExpr(%v, %v) = %q, expected %q", test.zones.Storage, test.zones.Display, result, test.expected)
+		}
+	}
+}
+
+func TestExportZonesLabel(t *testing.T) {
+	tests := []struct {
+		zone     string
+		expected string
+	}{
+		{"Asia/Kolkata", "IST"},
+		{"UTC", "UTC"},
+		{"Europe/Berlin", "Europe/Berlin"},
+	}
+
+	for _, test := range tests {
+		loc, _ := time.LoadLocation(test.zone)
+		if result := (exportZones{Display: loc}).label(); result != test.expected {
+			t.Errorf("label(%s) = %q, expected %q", test.zone, result, test.expected)
+		}
+	}
+}
+
+func TestResolveZones(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_108_gT_40E_P_S7_200_Germany", Timezone: "Europe/Berlin"}, {Table: "GTPL_121_GT1000T"}})
+	defer registry.set(nil)
+
+	tests := []struct {
+		tz              string
+		table           string
+		expectedStorage string
+		expectedDisplay string
+	}{
+		{"", "GTPL_108_gT_40E_P_S7_200_Germany", "Europe/Berlin", "Europe/Berlin"},
+		{"", "GTPL_121_GT1000T", DEFAULT_STORAGE_TIMEZONE, DEFAULT_STORAGE_TIMEZONE},
+		{"UTC", "GTPL_121_GT1000T", DEFAULT_STORAGE_TIMEZONE, "UTC"},
+	}
+
+	for _, test := range tests {
+		zones, err := resolveZones(test.tz, test.table)
+		if err != nil {
+			t.Fatalf("resolveZones(%q, %s) error: %v", test.tz, test.table, err)
+		}
+		if zones.Storage.String() != test.expectedStorage || zones.Display.String() != test.expectedDisplay {
+			t.Errorf("resolveZones(%q, %s) = %v/%v, expected %s/%s", test.tz, test.table, zones.Storage, zones.Display, test.expectedStorage, test.expectedDisplay)
+		}
+	}
+
+	for _, tz := range []string{"IST", "Local", "Mars/Olympus"} {
+		if _, err := resolveZones(tz, "GTPL_121_GT1000T"); err == nil {
+			t.Errorf("resolveZones(%q) returned no error", tz)
+		}
+	}
+}
+
+func TestCheckConvertTZ(t *testing.T) {
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	defer convertibleZones.Delete("Asia/Kolkata Europe/Berlin")
+
+	// Without time zone tables MySQL's CONVERT_TZ returns NULL
+	table := &fakeTable{columns: []string{"missing"}, types: []string{"BIGINT"}, rows: [][]driver.Value{{int64(1)}}}
+	database := sql.OpenDB(table)
+	defer database.Close()
+
+	err := exportZones{ist, berlin}.checkConvertTZ(context.Background(), database)
+	if reqErr, ok := err.(*RequestError); !ok || reqErr.Message != "Timezone not supported by the database" {
+		t.Errorf("checkConvertTZ(IST, Berlin) error = %v, expected a RequestError", err)
+	}
+	if err := (exportZones{ist, time.UTC}).checkConvertTZ(context.Background(), database); err != nil {
+		t.Errorf("checkConvertTZ(IST, UTC) error = %v, expected fixed offsets to need no check", err)
+	}
+
+	table.rows = [][]driver.Value{{int64(0)}}
+	if err := (exportZones{ist, berlin}).checkConvertTZ(context.Background(), database); err != nil {
+		t.Errorf("checkConvertTZ(IST, Berlin) error = %v, expected none with time zone tables", err)
+	}
+	if _, ok := convertibleZones.Load("Asia/Kolkata Europe/Berlin"); !ok {
+		t.Errorf("checkConvertTZ(IST, Berlin) did not remember the convertible pair")
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// RowWriter writes processed rows to an output stream in a specific format
+type RowWriter interface {
+	// WriteHeader is called once, before any rows, with the ordered column keys
+	WriteHeader(headers []string) error
+	// WriteRow writes a single row using the header order
+	WriteRow(row DataRow) error
+	// Flush writes any buffered output to the underlying stream
+	Flush() error
+	// Close releases resources held by the writer; it is safe to call after Flush
+	Close() error
+}
+
+// columnTypeSetter is implemented by writers that need the source column
+// types, e.g. to derive a typed schema. types maps output columns to MySQL
+// type names such as "DECIMAL". It is called before WriteHeader.
+type columnTypeSetter interface {
+	SetColumnTypes(types map[string]string)
+}
+
+// locationSetter is implemented by writers that store timestamps as instants
+// and need the zone normalized created_at strings are in. It is called before
+// WriteHeader.
+type locationSetter interface {
+	SetLocation(loc *time.Location)
+}
+
+// sheetWriter is implemented by writers that can hold extra sheets next to
+// the data, such as the fault episode report. It is called before Flush.
+type sheetWriter interface {
+	WriteSheet(name string, headers []string, rows [][]interface{}) error
+}
+
+// highlighted marks a value to be emphasised in formats that support
+// styling; other formats write the plain value
+type highlighted struct {
+	Value interface{}
+}
+
+// chartWriter is implemented by writers that can chart columns of the data
+// they hold. It is called after the rows, before Flush.
+type chartWriter interface {
+	WriteCharts(name string, charts []ChartSpec, newestFirst bool) error
+}
+
+// Sheet name used for exported data
+const DATA_SHEET = "Data"
+
+// Rows an Excel sheet holds, including the header row
+const XLSX_MAX_ROWS = 1048576
+
+// Data rows per sheet before an XLSX export rolls over to the next sheet,
+// or to the next file with split=rows. Set with SHEET_ROW_LIMIT.
+var SHEET_ROW_LIMIT = XLSX_MAX_ROWS - 1
+
+// Sheet name for charts of the exported data
+const CHARTS_SHEET = "Charts"
+
+// Rows between the tops of consecutive charts on the charts sheet
+const CHART_ROWS = 20
+
+// ExportFormat describes an output format selectable with format=
+type ExportFormat struct {
+	Extension   string
+	ContentType string
+	// NewWriter creates a writer; labels names the header profile, or nil for raw keys
+	NewWriter func(w io.Writer, labels *HeaderProfile) (RowWriter, error)
+}
+
+// Supported export formats
+var EXPORT_FORMATS = map[string]ExportFormat{
+	"xlsx": {
+		Extension:   "xlsx",
+		ContentType: XLSX_CONTENT_TYPE,
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newXLSXWriter(w, labels)
+		},
+	},
+	"csv": {
+		Extension:   "csv",
+		ContentType: "text/csv; charset=utf-8",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newDelimitedWriter(w, ',', labels), nil
+		},
+	},
+	"tsv": {
+		Extension:   "tsv",
+		ContentType: "text/tab-separated-values; charset=utf-8",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newDelimitedWriter(w, '\t', labels), nil
+		},
+	},
+	"json": {
+		Extension:   "json",
+		ContentType: "application/json; charset=utf-8",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newJSONWriter(w, true), nil
+		},
+	},
+	"ndjson": {
+		Extension:   "ndjson",
+		ContentType: "application/x-ndjson",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newJSONWriter(w, false), nil
+		},
+	},
+	"parquet": {
+		Extension:   "parquet",
+		ContentType: "application/vnd.apache.parquet",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newParquetWriter(w), nil
+		},
+	},
+}
+
+// Load the per-sheet row limit, capped at what Excel can open
+func loadSheetRowLimit() {
+	value := os.Getenv("SHEET_ROW_LIMIT")
+	if value == "" {
+		return
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > XLSX_MAX_ROWS-1 {
+		log.Printf("Invalid SHEET_ROW_LIMIT %q, using %d", value, XLSX_MAX_ROWS-1)
+		return
+	}
+	SHEET_ROW_LIMIT = limit
+	log.Printf("Sheets hold up to %d rows", limit)
+}
+
+// Get sorted names of supported export formats
+func exportFormatNames() []string {
+	names := make([]string, 0, len(EXPORT_FORMATS))
+	for name := range EXPORT_FORMATS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// xlsxWriter streams rows into a worksheet with excelize's StreamWriter.
+// The StreamWriter spills rows to a temp file once its buffer fills up, so
+// memory stays bounded; the zipped workbook is written to w on Flush.
+// Past maxRows rows a sheet rolls over to Data_2, Data_3... with the same
+// headers.
+type xlsxWriter struct {
+	w       io.Writer
+	f       *excelize.File
+	sheet   string
+	sw      *excelize.StreamWriter // nil until the current sheet is started
+	headers []string
+	labels  *HeaderProfile
+	rowNum  int
+	done    bool
+
+	styles      map[string]int // cell styles by name, see XLSX_STYLES
+	columnStyle []string       // style name per header, "" for none
+
+	// The header and first rows are held back until column widths are known
+	sampling bool
+	pending  [][]interface{}
+
+	maxRows    int    // data rows per sheet
+	base       string // name of the first sheet of the current data
+	part       int    // sheets the current data spans
+	firstSheet string // first sheet and its last row, once rolled over
+	firstRows  int
+}
+
+// Create a new streaming Excel writer
+func newXLSXWriter(w io.Writer, labels *HeaderProfile) (*xlsxWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", DATA_SHEET); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &xlsxWriter{w: w, f: f, sheet: DATA_SHEET, labels: labels, maxRows: SHEET_ROW_LIMIT, base: DATA_SHEET, part: 1}, nil
+}
+
+// Start streaming the current sheet
+func (x *xlsxWriter) startSheet() error {
+	if x.sw != nil {
+		return nil
+	}
+
+	sw, err := x.f.NewStreamWriter(x.sheet)
+	if err != nil {
+		return err
+	}
+	x.sw = sw
+	return nil
+}
+
+// NextSheet directs further headers and rows to a new sheet with its own
+// labels. The current sheet is finished first, or renamed if nothing has
+// been written to it yet.
+func (x *xlsxWriter) NextSheet(name string, labels *HeaderProfile) error {
+	if x.sw == nil && !x.done && x.headers == nil {
+		if err := x.f.SetSheetName(x.sheet, name); err != nil {
+			return err
+		}
+	} else {
+		if err := x.finishData(); err != nil {
+			return err
+		}
+		if _, err := x.f.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	x.sheet, x.sw, x.headers, x.labels, x.rowNum, x.done = name, nil, nil, labels, 0, false
+	x.sampling, x.pending = false, nil
+	x.base, x.part, x.firstSheet, x.firstRows = name, 1, "", 0
+	return nil
+}
+
+// Continue the current data on a new sheet, named after the first one with
+// the part number, repeating the headers
+func (x *xlsxWriter) rollOver() error {
+	if err := x.finishData(); err != nil {
+		return err
+	}
+	base, part, headers := x.base, x.part+1, x.headers
+	firstSheet, firstRows := x.firstSheet, x.firstRows
+	if part == 2 {
+		firstSheet, firstRows = x.sheet, x.rowNum
+	}
+
+	suffix := fmt.Sprintf("_%d", part)
+	if err := x.NextSheet(truncateRunes(base, 31-len(suffix))+suffix, x.labels); err != nil {
+		return err
+	}
+	x.base, x.part, x.firstSheet, x.firstRows = base, part, firstSheet, firstRows
+	return x.WriteHeader(headers)
+}
+
+// Count the data rows of the current sheet
+func (x *xlsxWriter) dataRows() int {
+	if x.sampling {
+		return len(x.pending)
+	}
+	return max(x.rowNum-1, 0)
+}
+
+// ReserveSheet adds an empty sheet at the current position for WriteSheet
+// to fill once the data is written
+func (x *xlsxWriter) ReserveSheet(name string) error {
+	if err := x.NextSheet(name, x.labels); err != nil {
+		return err
+	}
+	x.done = true
+	return nil
+}
+
+func (x *xlsxWriter) WriteHeader(headers []string) error {
+	x.headers = headers
+	x.columnStyle = columnStyleNames(headers, x.labels)
+	x.sampling = true
+	x.pending = make([][]interface{}, 0, XLSX_WIDTH_SAMPLE_ROWS)
+	return nil
+}
+
+func (x *xlsxWriter) WriteRow(row DataRow) error {
+	if x.maxRows > 0 && x.dataRows() >= x.maxRows {
+		if err := x.rollOver(); err != nil {
+			return err
+		}
+	}
+
+	values := make([]interface{}, len(x.headers))
+	for i, header := range x.headers {
+		value, exists := row[header]
+		if !exists {
+			continue
+		}
+		if h, ok := value.(highlighted); ok {
+			style, err := x.style("highlight")
+			if err != nil {
+				return err
+			}
+			value = excelize.Cell{StyleID: style, Value: h.Value}
+		} else if converted, ok := styledCellValue(x.columnStyle[i], value); ok {
+			style, err := x.style(x.columnStyle[i])
+			if err != nil {
+				return err
+			}
+			value = excelize.Cell{StyleID: style, Value: converted}
+		}
+		values[i] = value
+	}
+
+	if x.sampling {
+		x.pending = append(x.pending, values)
+		if len(x.pending) < XLSX_WIDTH_SAMPLE_ROWS {
+			return nil
+		}
+		return x.startData()
+	}
+	return x.writeValues(values)
+}
+
+// Write the header and the held back rows, with columns sized to fit them
+func (x *xlsxWriter) startData() error {
+	x.sampling = false
+	if err := x.startSheet(); err != nil {
+		return err
+	}
+
+	labels := make([]string, len(x.headers))
+	values := make([]interface{}, len(x.headers))
+	for i, header := range x.headers {
+		labels[i] = headerLabel(header, x.labels)
+		values[i] = labels[i]
+	}
+
+	// Column widths and panes must be set before any rows are streamed
+	if len(x.headers) > 0 {
+		for i, width := range columnWidths(labels, x.columnStyle, x.pending) {
+			if err := x.sw.SetColWidth(i+1, i+1, width); err != nil {
+				return err
+			}
+		}
+
+		// Keep the header row and the id/time columns in view
+		frozen := frozenColumns(x.headers)
+		topLeft, err := excelize.CoordinatesToCellName(frozen+1, 2)
+		if err != nil {
+			return err
+		}
+		pane := "bottomLeft"
+		if frozen > 0 {
+			pane = "bottomRight"
+		}
+		err = x.sw.SetPanes(&excelize.Panes{Freeze: true, XSplit: frozen, YSplit: 1, TopLeftCell: topLeft, ActivePane: pane})
+		if err != nil {
+			return err
+		}
+	}
+
+	headerStyle, err := x.style("header")
+	if err != nil {
+		return err
+	}
+	x.rowNum = 1
+	if err := x.sw.SetRow("A1", values, excelize.RowOpts{StyleID: headerStyle}); err != nil {
+		return err
+	}
+
+	for _, values := range x.pending {
+		if err := x.writeValues(values); err != nil {
+			return err
+		}
+	}
+	x.pending = nil
+	return nil
+}
+
+// Stream one row of cell values
+func (x *xlsxWriter) writeValues(values []interface{}) error {
+	x.rowNum++
+	cell, err := excelize.CoordinatesToCellName(1, x.rowNum)
+	if err != nil {
+		return err
+	}
+	return x.sw.SetRow(cell, values)
+}
+
+// Finish the data sheet; excelize needs each stream flushed before the next one starts
+func (x *xlsxWriter) finishData() error {
+	if x.done {
+		return nil
+	}
+	x.done = true
+
+	if x.sampling {
+		if err := x.startData(); err != nil {
+			return err
+		}
+	}
+	if err := x.startSheet(); err != nil {
+		return err
+	}
+	if x.rowNum <= 1 {
+		if err := x.sw.SetRow("A2", []interface{}{"No records found for selected criteria"}); err != nil {
+			return err
+		}
+	} else if err := x.formatDataSheet(); err != nil {
+		return fmt.Errorf("error formatting sheet: %v", err)
+	}
+
+	if err := x.sw.Flush(); err != nil {
+		return fmt.Errorf("error flushing sheet: %v", err)
+	}
+	return nil
+}
+
+// WriteSheet adds a sheet after the data sheet, or fills a reserved one
+func (x *xlsxWriter) WriteSheet(name string, headers []string, rows [][]interface{}) error {
+	if err := x.finishData(); err != nil {
+		return err
+	}
+
+	if index, _ := x.f.GetSheetIndex(name); index == -1 {
+		if _, err := x.f.NewSheet(name); err != nil {
+			return err
+		}
+	}
+	sw, err := x.f.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+
+	width := len(headers)
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	labels := make([]string, width)
+	copy(labels, headers)
+	for i, columnWidth := range columnWidths(labels, nil, rows) {
+		if err := sw.SetColWidth(i+1, i+1, columnWidth); err != nil {
+			return err
+		}
+	}
+
+	// Sheets without headers start with their first row
+	first := 1
+	if len(headers) > 0 {
+		values := make([]interface{}, len(headers))
+		for i, header := range headers {
+			values[i] = header
+		}
+		headerStyle, err := x.style("header")
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow("A1", values, excelize.RowOpts{StyleID: headerStyle}); err != nil {
+			return err
+		}
+		first = 2
+	}
+
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+first)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow(cell, row); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("error flushing sheet %s: %v", name, err)
+	}
+	return nil
+}
+
+// WriteCharts adds a sheet of line charts plotting data sheet columns
+// against created_at. Aggregated exports chart each column's average.
+// Columns missing from the export are left out, and the sheet is only
+// added when something can be charted. Data spread over several sheets is
+// charted from the first one.
+func (x *xlsxWriter) WriteCharts(name string, charts []ChartSpec, newestFirst bool) error {
+	if err := x.finishData(); err != nil {
+		return err
+	}
+	sheet, lastRow := x.sheet, x.rowNum
+	if x.part > 1 {
+		sheet, lastRow = x.firstSheet, x.firstRows
+	}
+
+	positions := make(map[string]int, len(x.headers))
+	for i, header := range x.headers {
+		positions[header] = i + 1
+	}
+	atColumn, ok := positions["created_at"]
+	if !ok || lastRow < 2 {
+		return nil
+	}
+	categories, err := columnRange(sheet, atColumn, 2, lastRow)
+	if err != nil {
+		return err
+	}
+
+	var prepared []*excelize.Chart
+	for _, spec := range charts {
+		var series []excelize.ChartSeries
+		for _, column := range spec.Columns {
+			position, ok := positions[column]
+			if !ok {
+				position, ok = positions[aggregateKey(column, "avg")]
+			}
+			if !ok {
+				continue
+			}
+
+			seriesName, err := columnRange(sheet, position, 1, 1)
+			if err != nil {
+				return err
+			}
+			values, err := columnRange(sheet, position, 2, lastRow)
+			if err != nil {
+				return err
+			}
+			series = append(series, excelize.ChartSeries{
+				Name:       seriesName,
+				Categories: categories,
+				Values:     values,
+				Marker:     excelize.ChartMarker{Symbol: "none"},
+			})
+		}
+		if len(series) == 0 {
+			continue
+		}
+
+		chart := &excelize.Chart{
+			Type:         excelize.Line,
+			Series:       series,
+			Title:        []excelize.RichTextRun{{Text: spec.Title}},
+			Dimension:    excelize.ChartDimension{Width: 960, Height: 380},
+			Legend:       excelize.ChartLegend{Position: "bottom"},
+			XAxis:        excelize.ChartAxis{ReverseOrder: newestFirst},
+			YAxis:        excelize.ChartAxis{MajorGridLines: true},
+			ShowBlanksAs: "gap",
+		}
+		if spec.Axis != "" {
+			chart.YAxis.Title = []excelize.RichTextRun{{Text: spec.Axis}}
+		}
+		prepared = append(prepared, chart)
+	}
+	if len(prepared) == 0 {
+		return nil
+	}
+
+	if _, err := x.f.NewSheet(name); err != nil {
+		return err
+	}
+	for i, chart := range prepared {
+		if err := x.f.AddChart(name, fmt.Sprintf("A%d", 1+i*CHART_ROWS), chart); err != nil {
+			return fmt.Errorf("error adding chart %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Get an absolute reference to rows first to last of a column, e.g. 'Data'!$B$2:$B$100
+func columnRange(sheet string, column, first, last int) (string, error) {
+	name, err := excelize.ColumnNumberToName(column)
+	if err != nil {
+		return "", err
+	}
+
+	sheet = "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+	if first == last {
+		return fmt.Sprintf("%s!$%s$%d", sheet, name, first), nil
+	}
+	return fmt.Sprintf("%s!$%s$%d:$%s$%d", sheet, name, first, name, last), nil
+}
+
+func (x *xlsxWriter) Flush() error {
+	if err := x.finishData(); err != nil {
+		return err
+	}
+
+	return x.f.Write(x.w)
+}
+
+func (x *xlsxWriter) Close() error {
+	// Removes any temp files backing the stream
+	return x.f.Close()
+}
+
+// Get display label for a column key
+func headerLabel(key string, labels *HeaderProfile) string {
+	if labels == nil {
+		return key
+	}
+	return labels.label(key)
+}
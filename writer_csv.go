@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// delimitedWriter streams rows as CSV or TSV. encoding/csv buffers only a
+// few KB before writing through, so rows reach the client as they are produced.
+type delimitedWriter struct {
+	cw      *csv.Writer
+	headers []string
+	labels  *HeaderProfile
+	record  []string
+}
+
+// Create a new CSV/TSV writer using the given field delimiter
+func newDelimitedWriter(w io.Writer, comma rune, labels *HeaderProfile) *delimitedWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &delimitedWriter{cw: cw, labels: labels}
+}
+
+func (d *delimitedWriter) WriteHeader(headers []string) error {
+	d.headers = headers
+	d.record = make([]string, len(headers))
+
+	for i, header := range headers {
+		d.record[i] = headerLabel(header, d.labels)
+	}
+	return d.cw.Write(d.record)
+}
+
+func (d *delimitedWriter) WriteRow(row DataRow) error {
+	for i, header := range d.headers {
+		d.record[i] = formatTextValue(row[header])
+	}
+	return d.cw.Write(d.record)
+}
+
+func (d *delimitedWriter) Flush() error {
+	d.cw.Flush()
+	return d.cw.Error()
+}
+
+func (d *delimitedWriter) Close() error {
+	return nil
+}
+
+// Format a cell value as text for delimited output
+func formatTextValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05")
+	case highlighted:
+		return formatTextValue(val.Value)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonWriter streams rows as a JSON array or as newline-delimited JSON.
+// Object keys are always column keys (never PRETTY_HEADER_MAP labels) and
+// keep the header order rather than the alphabetical order of encoding/json.
+type jsonWriter struct {
+	bw      *bufio.Writer
+	headers []string
+	keys    [][]byte
+	numeric map[string]bool // columns of a numeric MySQL type
+	array   bool
+	rows    int
+}
+
+// Create a new JSON writer; array selects a JSON array over NDJSON
+func newJSONWriter(w io.Writer, array bool) *jsonWriter {
+	return &jsonWriter{bw: bufio.NewWriter(w), array: array}
+}
+
+// SetColumnTypes records which columns are numeric, so that raw DECIMAL
+// values are written as numbers rather than strings
+func (j *jsonWriter) SetColumnTypes(types map[string]string) {
+	j.numeric = make(map[string]bool, len(types))
+	for column, databaseType := range types {
+		j.numeric[column] = isNumericTypeName(databaseType)
+	}
+}
+
+func (j *jsonWriter) WriteHeader(headers []string) error {
+	j.headers = headers
+	j.keys = make([][]byte, len(headers))
+
+	// Pre-encode keys once since they repeat on every row
+	for i, header := range headers {
+		key, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+
+	if j.array {
+		return j.bw.WriteByte('[')
+	}
+	return nil
+}
+
+func (j *jsonWriter) WriteRow(row DataRow) error {
+	if j.array && j.rows > 0 {
+		j.bw.WriteByte(',')
+	}
+	j.rows++
+
+	j.bw.WriteByte('{')
+	for i, header := range j.headers {
+		v := row[header]
+		if j.numeric[header] {
+			v = toNum(v)
+		}
+		value, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			j.bw.WriteByte(',')
+		}
+		j.bw.Write(j.keys[i])
+		j.bw.WriteByte(':')
+		j.bw.Write(value)
+	}
+	j.bw.WriteByte('}')
+
+	if !j.array {
+		return j.bw.WriteByte('\n')
+	}
+	return nil
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.array {
+		j.bw.WriteByte(']')
+	}
+	return j.bw.Flush()
+}
+
+func (j *jsonWriter) Close() error {
+	return nil
+}
+
+// Convert a row value into something encoding/json renders sensibly
+func jsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		// MySQL returns DECIMAL and text columns as bytes, which would
+		// otherwise be base64 encoded
+		return string(val)
+	case string:
+		// Empty strings stand in for NULL throughout the pipeline
+		if val == "" {
+			return nil
+		}
+		return val
+	default:
+		return val
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Parquet column kinds derived from MySQL column types
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetInt64
+	parquetDouble
+	parquetBoolean
+	parquetTimestamp
+)
+
+// parquetWriter streams rows into a Parquet file with a typed schema. Rows are
+// buffered per row group, so memory is bounded by CHUNK_SIZE rows; the footer
+// is written on Flush.
+type parquetWriter struct {
+	w        io.Writer
+	pw       *parquet.Writer
+	kinds    map[string]parquetKind
+	fields   []string
+	buffered int
+	row      parquet.Row
+	loc      *time.Location
+}
+
+// Create a new Parquet writer
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{w: w, kinds: make(map[string]parquetKind), loc: time.Local}
+}
+
+// SetColumnTypes records the MySQL type of each source column
+func (p *parquetWriter) SetColumnTypes(types map[string]string) {
+	for column, databaseType := range types {
+		p.kinds[column] = parquetKindOf(databaseType, column)
+	}
+}
+
+// SetLocation sets the zone of normalized timestamp strings
+func (p *parquetWriter) SetLocation(loc *time.Location) {
+	p.loc = loc
+}
+
+func (p *parquetWriter) WriteHeader(headers []string) error {
+	// Derived columns such as created_at_date or Faults have no source type
+	// and are written as strings
+	group := parquet.Group{}
+	for _, header := range headers {
+		group[header] = parquet.Optional(parquetNode(p.kinds[header]))
+	}
+
+	schema := parquet.NewSchema("export", orderedGroup{Group: group, order: headers})
+	p.fields = headers
+	p.row = make(parquet.Row, len(p.fields))
+
+	config, err := parquet.NewWriterConfig(schema, parquet.MaxRowsPerRowGroup(CHUNK_SIZE))
+	if err != nil {
+		return err
+	}
+	p.pw = parquet.NewWriter(p.w, config)
+	return nil
+}
+
+func (p *parquetWriter) WriteRow(row DataRow) error {
+	for i, field := range p.fields {
+		value := parquetValue(p.kinds[field], row[field], p.loc)
+		if value.IsNull() {
+			p.row[i] = value.Level(0, 0, i)
+		} else {
+			p.row[i] = value.Level(0, 1, i)
+		}
+	}
+
+	if _, err := p.pw.WriteRows([]parquet.Row{p.row}); err != nil {
+		return err
+	}
+
+	// Close the row group once it reaches CHUNK_SIZE rows
+	p.buffered++
+	if p.buffered >= CHUNK_SIZE {
+		p.buffered = 0
+		return p.pw.Flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) Flush() error {
+	return p.pw.Close()
+}
+
+func (p *parquetWriter) Close() error {
+	return nil
+}
+
+// orderedGroup is a parquet.Group whose fields keep the header order instead
+// of being sorted by name
+type orderedGroup struct {
+	parquet.Group
+	order []string
+}
+
+func (g orderedGroup) Fields() []parquet.Field {
+	byName := make(map[string]parquet.Field, len(g.Group))
+	for _, field := range g.Group.Fields() {
+		byName[field.Name()] = field
+	}
+
+	fields := make([]parquet.Field, len(g.order))
+	for i, name := range g.order {
+		fields[i] = byName[name]
+	}
+	return fields
+}
+
+// Map a MySQL column type to a Parquet column kind
+func parquetKindOf(databaseType, column string) parquetKind {
+	switch strings.TrimPrefix(strings.ToUpper(databaseType), "UNSIGNED ") {
+	case "DATETIME", "TIMESTAMP", "DATE":
+		return parquetTimestamp
+	case "DECIMAL", "FLOAT", "DOUBLE":
+		return parquetDouble
+	case "TINYINT":
+		// TINYINT(1) columns hold the PLC fault flags
+		if looksLikeFaultKey(column) {
+			return parquetBoolean
+		}
+		return parquetInt64
+	case "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return parquetInt64
+	default:
+		return parquetString
+	}
+}
+
+// Get the Parquet schema node for a column kind
+func parquetNode(kind parquetKind) parquet.Node {
+	switch kind {
+	case parquetInt64:
+		return parquet.Leaf(parquet.Int64Type)
+	case parquetDouble:
+		return parquet.Leaf(parquet.DoubleType)
+	case parquetBoolean:
+		return parquet.Leaf(parquet.BooleanType)
+	case parquetTimestamp:
+		return parquet.Timestamp(parquet.Millisecond)
+	default:
+		return parquet.String()
+	}
+}
+
+// Convert a row value to a Parquet value of the given kind, or null if it
+// cannot be represented
+func parquetValue(kind parquetKind, v interface{}, loc *time.Location) parquet.Value {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if v == nil || v == "" {
+		return parquet.NullValue()
+	}
+
+	switch kind {
+	case parquetInt64:
+		switch val := v.(type) {
+		case int64:
+			return parquet.Int64Value(val)
+		case int:
+			return parquet.Int64Value(int64(val))
+		case string:
+			if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return parquet.Int64Value(i)
+			}
+		}
+		if f, ok := toFloat(v); ok {
+			return parquet.Int64Value(int64(f))
+		}
+	case parquetDouble:
+		if f, ok := toFloat(v); ok {
+			return parquet.DoubleValue(f)
+		}
+	case parquetBoolean:
+		// Fault flags are active only when 1, as for Faults and episodes
+		return parquet.BooleanValue(isTrueish(v))
+	case parquetTimestamp:
+		switch val := v.(type) {
+		case time.Time:
+			return parquet.Int64Value(val.UnixMilli())
+		case string:
+			// Timestamps are normalized to "2006-01-02 15:04:05" strings in loc
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", val, loc); err == nil {
+				return parquet.Int64Value(t.UnixMilli())
+			}
+			if t, err := time.ParseInLocation("2006-01-02", val, loc); err == nil {
+				return parquet.Int64Value(t.UnixMilli())
+			}
+		}
+	default:
+		return parquet.ByteArrayValue([]byte(formatTextValue(v)))
+	}
+
+	return parquet.NullValue()
+}
+
+// Convert a numeric value to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case string:
+		f, err := strconv.ParseFloat(val, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Number formats of XLSX columns by unit
+var UNIT_NUMBER_FORMATS = map[string]string{
+	"°C":  "0.0",
+	"%":   "0.0",
+	"bar": "0.00",
+	"s":   "0",
+	"h":   "0",
+}
+
+// Rows held back to size the columns of a data sheet
+const XLSX_WIDTH_SAMPLE_ROWS = 100
+
+// Column width bounds in characters. Longer header labels wrap rather than
+// widen their column.
+const (
+	MIN_COLUMN_WIDTH = 8
+	MAX_COLUMN_WIDTH = 60
+	MAX_HEADER_WIDTH = 24
+)
+
+// Leading columns kept in view when scrolling right
+var FROZEN_COLUMNS = map[string]bool{"id": true, "created_at": true, "created_at_date": true, "created_at_time": true}
+
+// Cell styles of the data sheet, created on first use
+var XLSX_STYLES = map[string]*excelize.Style{
+	"header": {
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9E1F2"}},
+		Alignment: &excelize.Alignment{Vertical: "center", WrapText: true},
+		Border:    []excelize.Border{{Type: "bottom", Color: "8EA9DB", Style: 1}},
+	},
+	"datetime":  {CustomNumFmt: stringPtr("yyyy-mm-dd hh:mm:ss")},
+	"date":      {CustomNumFmt: stringPtr("yyyy-mm-dd")},
+	"time":      {CustomNumFmt: stringPtr("hh:mm:ss")},
+	"highlight": {Font: &excelize.Font{Color: "9C0006"}, Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}}},
+}
+
+// Conditional formats for rows with faults and values outside their limits
+var (
+	FAULT_ROW_FORMAT     = &excelize.Style{Font: &excelize.Font{Color: "9C5700"}, Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFEB9C"}}}
+	OUT_OF_LIMITS_FORMAT = &excelize.Style{Font: &excelize.Font{Color: "9C0006", Bold: true}, Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}}}
+)
+
+// Get a pointer to a string
+func stringPtr(s string) *string {
+	return &s
+}
+
+// Get a named cell style, or a number format style for "numfmt:<format>"
+func (x *xlsxWriter) style(name string) (int, error) {
+	if id, ok := x.styles[name]; ok {
+		return id, nil
+	}
+
+	style, ok := XLSX_STYLES[name]
+	if !ok {
+		format, isFormat := strings.CutPrefix(name, "numfmt:")
+		if !isFormat {
+			return 0, fmt.Errorf("unknown cell style %s", name)
+		}
+		style = &excelize.Style{CustomNumFmt: &format}
+	}
+
+	id, err := x.f.NewStyle(style)
+	if err != nil {
+		return 0, err
+	}
+	if x.styles == nil {
+		x.styles = make(map[string]int)
+	}
+	x.styles[name] = id
+	return id, nil
+}
+
+// Get the style name of each column: date and time formats for created_at
+// and its parts, and a number format for columns with a known unit
+func columnStyleNames(headers []string, labels *HeaderProfile) []string {
+	profile := labels
+	if profile == nil {
+		profile = DEFAULT_PROFILE
+	}
+
+	names := make([]string, len(headers))
+	for i, header := range headers {
+		switch header {
+		case "created_at":
+			names[i] = "datetime"
+		case "created_at_date":
+			names[i] = "date"
+		case "created_at_time":
+			names[i] = "time"
+		default:
+			if format, ok := UNIT_NUMBER_FORMATS[profile.unit(header)]; ok {
+				names[i] = "numfmt:" + format
+			}
+		}
+	}
+	return names
+}
+
+// Size columns to fit their header label and the sample rows' values
+func columnWidths(labels, styles []string, rows [][]interface{}) []float64 {
+	widths := make([]float64, len(labels))
+	for i, label := range labels {
+		style := ""
+		if i < len(styles) {
+			style = styles[i]
+		}
+
+		chars := min(utf8.RuneCountInString(label), MAX_HEADER_WIDTH)
+		for _, row := range rows {
+			if i < len(row) {
+				chars = max(chars, displayWidth(style, row[i]))
+			}
+		}
+		widths[i] = float64(min(max(chars+2, MIN_COLUMN_WIDTH), MAX_COLUMN_WIDTH))
+	}
+	return widths
+}
+
+// Get the number of characters a cell value is shown with
+func displayWidth(style string, value interface{}) int {
+	if cell, ok := value.(excelize.Cell); ok {
+		value = cell.Value
+	}
+
+	switch value.(type) {
+	case nil:
+		return 0
+	case time.Time:
+		if style == "date" {
+			return len("2006-01-02")
+		}
+		return len("2006-01-02 15:04:05")
+	case float64:
+		if style == "time" {
+			return len("15:04:05")
+		}
+	}
+
+	if format, ok := strings.CutPrefix(style, "numfmt:"); ok {
+		if f, isNumber := toNum(aggregateValue(value)).(float64); isNumber {
+			decimals := 0
+			if _, fraction, hasFraction := strings.Cut(format, "."); hasFraction {
+				decimals = len(fraction)
+			}
+			return len(strconv.FormatFloat(f, 'f', decimals, 64))
+		}
+	}
+	return utf8.RuneCountInString(formatTextValue(value))
+}
+
+// Count the leading id and time columns to freeze
+func frozenColumns(headers []string) int {
+	n := 0
+	for n < len(headers) && FROZEN_COLUMNS[headers[n]] {
+		n++
+	}
+	return n
+}
+
+// Convert a value for a styled cell. created_at strings become Excel dates
+// and times; values that do not fit the column's style are left as they are.
+func styledCellValue(style string, value interface{}) (interface{}, bool) {
+	text, isText := value.(string)
+	switch style {
+	case "":
+		return value, false
+	case "datetime", "date":
+		layout := "2006-01-02 15:04:05"
+		if style == "date" {
+			layout = "2006-01-02"
+		}
+		if t, ok := value.(time.Time); ok {
+			return t, true
+		}
+		if !isText {
+			return value, false
+		}
+		// Excel serials count wall-clock time, so the zone is irrelevant
+		t, err := time.ParseInLocation(layout, text, time.UTC)
+		if err != nil {
+			return value, false
+		}
+		return t, true
+	case "time":
+		if !isText {
+			return value, false
+		}
+		t, err := time.ParseInLocation("15:04:05", text, time.UTC)
+		if err != nil {
+			return value, false
+		}
+		// A time of day is the fraction of a day
+		return float64(t.Hour()*3600+t.Minute()*60+t.Second()) / 86400, true
+	default:
+		switch value.(type) {
+		case float64, float32, int, int64:
+			return value, true
+		case []byte:
+			if f, err := strconv.ParseFloat(string(value.([]byte)), 64); err == nil {
+				return f, true
+			}
+		}
+		return value, false
+	}
+}
+
+// Add the autofilter and conditional formats once all rows are written.
+// The stream writer serializes the worksheet settings on Flush, so they must
+// be set before it.
+func (x *xlsxWriter) formatDataSheet() error {
+	if len(x.headers) == 0 || x.rowNum < 2 {
+		return nil
+	}
+
+	lastColumn, err := excelize.ColumnNumberToName(len(x.headers))
+	if err != nil {
+		return err
+	}
+	if err := x.f.AutoFilter(x.sheet, fmt.Sprintf("A1:%s%d", lastColumn, x.rowNum), nil); err != nil {
+		return err
+	}
+
+	positions := make(map[string]string, len(x.headers))
+	for i, header := range x.headers {
+		name, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		positions[header] = name
+	}
+
+	// Whole rows with faults
+	if faults, ok := positions["Faults"]; ok {
+		style, err := x.f.NewConditionalStyle(FAULT_ROW_FORMAT)
+		if err != nil {
+			return err
+		}
+		err = x.f.SetConditionalFormat(x.sheet, fmt.Sprintf("A2:%s%d", lastColumn, x.rowNum), []excelize.ConditionalFormatOptions{
+			{Type: "formula", Criteria: fmt.Sprintf("LEN($%s2)>0", faults), Format: style},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Cells outside their set-point range
+	profile := x.labels
+	if profile == nil {
+		profile = DEFAULT_PROFILE
+	}
+	style := -1
+	for _, header := range x.headers {
+		limit, ok := profile.limits()[header]
+		if !ok {
+			continue
+		}
+		rules := limitRules(positions[header], limit, positions)
+		if len(rules) == 0 {
+			continue
+		}
+
+		if style < 0 {
+			if style, err = x.f.NewConditionalStyle(OUT_OF_LIMITS_FORMAT); err != nil {
+				return err
+			}
+		}
+		options := make([]excelize.ConditionalFormatOptions, len(rules))
+		for i, rule := range rules {
+			options[i] = excelize.ConditionalFormatOptions{Type: "formula", Criteria: rule, Format: style}
+		}
+		column := positions[header]
+		if err := x.f.SetConditionalFormat(x.sheet, fmt.Sprintf("%s2:%s%d", column, column, x.rowNum), options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build conditional format formulas, relative to row 2, that flag a column's
+// values outside its limits. Bounds name a column of the export or a number;
+// bounds on columns that are not exported are skipped.
+func limitRules(column string, limit ColumnLimit, positions map[string]string) []string {
+	var rules []string
+	for _, bound := range []struct {
+		value string
+		op    string
+	}{{limit.Min, "<"}, {limit.Max, ">"}} {
+		if bound.value == "" {
+			continue
+		}
+
+		ref := ""
+		if position, ok := positions[bound.value]; ok {
+			ref = position + "2"
+		} else if _, err := strconv.ParseFloat(bound.value, 64); err == nil {
+			ref = bound.value
+		} else {
+			continue
+		}
+
+		checks := "ISNUMBER(" + column + "2)"
+		if ref != bound.value {
+			checks += ",ISNUMBER(" + ref + ")"
+		}
+		rules = append(rules, fmt.Sprintf("AND(%s,%s2%s%s)", checks, column, bound.op, ref))
+	}
+	return rules
+}
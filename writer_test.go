@@ -0,0 +1,853 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteHeader([]string{"id", "LP_value", "custom"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := w.WriteRow(DataRow{"id": 1, "LP_value": 2.5, "custom": "x"}); err != nil {
+		t.Fatalf("WriteRow() error: %v", err)
+	}
+	if err := w.WriteRow(DataRow{"id": 2}); err != nil {
+		t.Fatalf("WriteRow() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		cell     string
+		expected string
+	}{
+		{"A1", "Record#"},
+		{"B1", "LP Value"},
+		{"C1", "custom"},
+		{"A2", "1"},
+		{"B2", "2.5"},
+		{"C2", "x"},
+		{"A3", "2"},
+		{"B3", ""},
+	}
+
+	for _, test := range tests {
+		result, err := f.GetCellValue(DATA_SHEET, test.cell)
+		if err != nil {
+			t.Fatalf("GetCellValue(%s) error: %v", test.cell, err)
+		}
+		if result != test.expected {
+			t.Errorf("cell %s = %q, expected %q", test.cell, result, test.expected)
+		}
+	}
+}
+
+func TestDelimitedWriter(t *testing.T) {
+	tests := []struct {
+		comma    rune
+		labels   *HeaderProfile
+		expected string
+	}{
+		{',', DEFAULT_PROFILE, "Record#,LP Value,note\n1,2.5,\"a,b\"\n"},
+		{',', &HeaderProfile{Labels: map[string]string{"LP_value": "Low Pressure"}, Units: map[string]string{"LP_value": "bar"}}, "Record#,Low Pressure (bar),note\n1,2.5,\"a,b\"\n"},
+		{'\t', nil, "id\tLP_value\tnote\n1\t2.5\ta,b\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newDelimitedWriter(&buf, test.comma, test.labels)
+		if err := w.WriteHeader([]string{"id", "LP_value", "note"}); err != nil {
+			t.Fatalf("WriteHeader() error: %v", err)
+		}
+		if err := w.WriteRow(DataRow{"id": int64(1), "LP_value": 2.5, "note": "a,b"}); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("delimited output = %q, expected %q", buf.String(), test.expected)
+		}
+	}
+}
+
+func TestJSONWriterRawDecimal(t *testing.T) {
+	table := &fakeTable{
+		columns: []string{"id", "created_at", "LP_value", "mode"},
+		types:   []string{"INT", "DATETIME", "DECIMAL", "VARCHAR"},
+		rows: [][]driver.Value{
+			{int64(1), time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC), []byte("3.10"), []byte("12")},
+			{int64(2), time.Date(2024, 7, 1, 10, 1, 0, 0, time.UTC), nil, []byte("auto")},
+		},
+	}
+	database := sql.OpenDB(table)
+	defer database.Close()
+
+	tx, err := database.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var buf bytes.Buffer
+	q := ExportQuery{Table: "GTPL_110", Order: "asc", Zones: exportZones{Storage: time.UTC, Display: time.UTC}}
+	if _, err := writeExport(context.Background(), newJSONWriter(&buf, false), tx, q, len(table.rows)); err != nil {
+		t.Fatalf("writeExport() error: %v", err)
+	}
+
+	// DECIMAL values are numbers while VARCHAR values stay text
+	expected := "{\"id\":1,\"created_at\":\"2024-07-01 10:00:00\",\"LP_value\":3.1,\"mode\":\"12\"}\n" +
+		"{\"id\":2,\"created_at\":\"2024-07-01 10:01:00\",\"LP_value\":null,\"mode\":\"auto\"}\n"
+	if buf.String() != expected {
+		t.Errorf("json output = %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	tests := []struct {
+		array    bool
+		rows     []DataRow
+		expected string
+	}{
+		{true, []DataRow{{"id": 1, "LP_value": 2.5}, {"id": 2, "LP_value": ""}}, `[{"id":1,"LP_value":2.5},{"id":2,"LP_value":null}]`},
+		{true, nil, `[]`},
+		{false, []DataRow{{"id": 1, "LP_value": []byte("3.10")}, {"id": 2}}, "{\"id\":1,\"LP_value\":\"3.10\"}\n{\"id\":2,\"LP_value\":null}\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newJSONWriter(&buf, test.array)
+		if err := w.WriteHeader([]string{"id", "LP_value"}); err != nil {
+			t.Fatalf("WriteHeader() error: %v", err)
+		}
+		for _, row := range test.rows {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatalf("WriteRow() error: %v", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("json output = %q, expected %q", buf.String(), test.expected)
+		}
+	}
+}
+
+func TestParquetKindOf(t *testing.T) {
+	tests := []struct {
+		databaseType string
+		column       string
+		expected     parquetKind
+	}{
+		{"DATETIME", "created_at", parquetTimestamp},
+		{"DECIMAL", "LP_value", parquetDouble},
+		{"FLOAT", "T1_temp_mean", parquetDouble},
+		{"TINYINT", "overheat_protection", parquetBoolean},
+		{"TINYINT", "FS", parquetInt64},
+		{"UNSIGNED BIGINT", "id", parquetInt64},
+		{"VARCHAR", "note", parquetString},
+	}
+
+	for _, test := range tests {
+		result := parquetKindOf(test.databaseType, test.column)
+		if result != test.expected {
+			t.Errorf("parquetKindOf(%s, %s) = %v, expected %v", test.databaseType, test.column, result, test.expected)
+		}
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf)
+	w.kinds = map[string]parquetKind{"id": parquetInt64, "LP_value": parquetDouble, "door_open": parquetBoolean}
+
+	if err := w.WriteHeader([]string{"id", "LP_value", "door_open", "Faults"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	rows := []DataRow{
+		{"id": int64(1), "LP_value": []byte("2.50"), "door_open": int64(1), "Faults": "door open"},
+		{"id": int64(2), "LP_value": "", "door_open": int64(2), "Faults": ""},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	if f.NumRows() != 2 {
+		t.Errorf("NumRows() = %d, expected 2", f.NumRows())
+	}
+
+	read := make([]parquet.Row, 2)
+	n, _ := f.RowGroups()[0].Rows().ReadRows(read)
+	if n != 2 {
+		t.Fatalf("ReadRows() = %d, expected 2", n)
+	}
+
+	// Columns keep the header order
+	var names []string
+	for _, field := range f.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+	if expected := []string{"id", "LP_value", "door_open", "Faults"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Fields() = %v, expected %v", names, expected)
+	}
+
+	if v := read[1][0].Int64(); v != 2 {
+		t.Errorf("id = %v, expected 2", v)
+	}
+	if v := read[0][1].Double(); v != 2.5 {
+		t.Errorf("LP_value = %v, expected 2.5", v)
+	}
+	if !read[1][1].IsNull() {
+		t.Errorf("LP_value = %v, expected null", read[1][1])
+	}
+	if !read[0][2].Boolean() || read[1][2].Boolean() {
+		t.Errorf("door_open = %v, %v, expected true, false", read[0][2], read[1][2])
+	}
+	if v := string(read[0][3].ByteArray()); v != "door open" {
+		t.Errorf("Faults = %q, expected %q", v, "door open")
+	}
+}
+
+func TestXLSXWriterSheet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteHeader([]string{"id"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := w.WriteRow(DataRow{"id": 1}); err != nil {
+		t.Fatalf("WriteRow() error: %v", err)
+	}
+	if err := w.WriteSheet(EPISODES_SHEET, []string{"Fault", "Rows"}, [][]interface{}{{"door open", 3}}); err != nil {
+		t.Fatalf("WriteSheet() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	if sheets := f.GetSheetList(); len(sheets) != 2 || sheets[1] != EPISODES_SHEET {
+		t.Errorf("GetSheetList() = %v, expected [%s %s]", sheets, DATA_SHEET, EPISODES_SHEET)
+	}
+	if value, _ := f.GetCellValue(EPISODES_SHEET, "B2"); value != "3" {
+		t.Errorf("episode cell B2 = %q, expected \"3\"", value)
+	}
+	if value, _ := f.GetCellValue(DATA_SHEET, "A2"); value != "1" {
+		t.Errorf("data cell A2 = %q, expected \"1\"", value)
+	}
+}
+
+func TestXLSXWriterCharts(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	// Aggregated exports chart the averages
+	if err := w.WriteHeader([]string{"created_at", "T1_temp_mean", "LP_value__avg"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		row := DataRow{"created_at": fmt.Sprintf("2024-07-01 10:0%d:00", i), "T1_temp_mean": 20.0 + float64(i), "LP_value__avg": 4.0}
+		if err := w.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	charts := append(DEFAULT_CHARTS, ChartSpec{Title: "Missing", Columns: []string{"HP_set_point"}})
+	if err := w.WriteCharts(CHARTS_SHEET, charts, false); err != nil {
+		t.Fatalf("WriteCharts() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error: %v", err)
+	}
+	var chartXML []string
+	for _, file := range archive.File {
+		if !strings.HasPrefix(file.Name, "xl/charts/chart") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error: %v", file.Name, err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		chartXML = append(chartXML, string(data))
+	}
+
+	// The chart without exported columns is skipped
+	if len(chartXML) != 2 {
+		t.Fatalf("workbook has %d charts, expected 2", len(chartXML))
+	}
+	all := html.UnescapeString(strings.Join(chartXML, ""))
+	for _, ref := range []string{"'Data'!$A$2:$A$4", "'Data'!$B$2:$B$4", "'Data'!$C$2:$C$4", "'Data'!$B$1"} {
+		if !strings.Contains(all, ref) {
+			t.Errorf("charts do not reference %s", ref)
+		}
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+	if sheets := f.GetSheetList(); len(sheets) != 2 || sheets[1] != CHARTS_SHEET {
+		t.Errorf("GetSheetList() = %v, expected [%s %s]", sheets, DATA_SHEET, CHARTS_SHEET)
+	}
+}
+
+func TestColumnRange(t *testing.T) {
+	tests := []struct {
+		sheet    string
+		column   int
+		first    int
+		last     int
+		expected string
+	}{
+		{"Data", 2, 2, 100, "'Data'!$B$2:$B$100"},
+		{"Data", 28, 1, 1, "'Data'!$AB$1"},
+		{"Bob's Dryer", 1, 2, 3, "'Bob''s Dryer'!$A$2:$A$3"},
+	}
+
+	for _, test := range tests {
+		result, err := columnRange(test.sheet, test.column, test.first, test.last)
+		if err != nil || result != test.expected {
+			t.Errorf("columnRange(%q, %d, %d, %d) = %q, %v, expected %q", test.sheet, test.column, test.first, test.last, result, err, test.expected)
+		}
+	}
+}
+
+func TestXLSXWriterStyles(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	headers := []string{"id", "created_at", "created_at_date", "created_at_time", "LP_value", "LP_set_point", "T1_temp_mean", "Faults"}
+	if err := w.WriteHeader(headers); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	rows := []DataRow{
+		{"id": 2, "created_at": "2024-07-01 10:01:00", "created_at_date": "2024-07-01", "created_at_time": "10:01:00", "LP_value": 2.5, "LP_set_point": 3.0, "T1_temp_mean": 18.26, "Faults": "LP fault"},
+		{"id": 1, "created_at": "2024-07-01 10:00:00", "created_at_date": "2024-07-01", "created_at_time": "10:00:00", "LP_value": 3.5, "LP_set_point": 3.0, "T1_temp_mean": 18.5, "Faults": ""},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	// Dates and times are Excel serials shown with a date format
+	tests := []struct {
+		cell        string
+		expected    string
+		expectedRaw string
+	}{
+		{"B2", "2024-07-01 10:01:00", "45474.4173611111"},
+		{"C2", "2024-07-01", "45474"},
+		{"D3", "10:00:00", "0.416666666666667"},
+		{"G2", "18.3", "18.26"},
+		{"H2", "LP fault", "LP fault"},
+	}
+	for _, test := range tests {
+		value, _ := f.GetCellValue(DATA_SHEET, test.cell)
+		raw, _ := f.GetCellValue(DATA_SHEET, test.cell, excelize.Options{RawCellValue: true})
+		if value != test.expected || raw != test.expectedRaw {
+			t.Errorf("cell %s = %q (raw %q), expected %q (raw %q)", test.cell, value, raw, test.expected, test.expectedRaw)
+		}
+	}
+
+	panes, err := f.GetPanes(DATA_SHEET)
+	if err != nil || !panes.Freeze || panes.XSplit != 4 || panes.YSplit != 1 || panes.TopLeftCell != "E2" {
+		t.Errorf("GetPanes() = %+v, %v, expected header row and 4 columns frozen", panes, err)
+	}
+
+	if style, _ := f.GetCellStyle(DATA_SHEET, "A1"); style == 0 {
+		t.Errorf("header style = 0, expected a header style")
+	}
+
+	formats, err := f.GetConditionalFormats(DATA_SHEET)
+	if err != nil {
+		t.Fatalf("GetConditionalFormats() error: %v", err)
+	}
+	expectedFormats := map[string]string{
+		"A2:H3": "LEN($H2)>0",
+		"E2:E3": "AND(ISNUMBER(E2),ISNUMBER(F2),E2<F2)",
+	}
+	for ref, criteria := range expectedFormats {
+		if len(formats[ref]) != 1 || formats[ref][0].Criteria != criteria {
+			t.Errorf("conditional format %s = %+v, expected %s", ref, formats[ref], criteria)
+		}
+	}
+
+	filtered := false
+	for _, name := range f.GetDefinedName() {
+		if name.Name == "_xlnm._FilterDatabase" && name.RefersTo == "'Data'!$A$1:$H$3" {
+			filtered = true
+		}
+	}
+	if !filtered {
+		t.Errorf("GetDefinedName() = %+v, expected an autofilter on 'Data'!$A$1:$H$3", f.GetDefinedName())
+	}
+}
+
+func TestLimitRules(t *testing.T) {
+	positions := map[string]string{"LP_value": "E", "LP_set_point": "F", "HP_value": "G"}
+
+	tests := []struct {
+		column   string
+		limit    ColumnLimit
+		expected []string
+	}{
+		{"E", ColumnLimit{Min: "LP_set_point"}, []string{"AND(ISNUMBER(E2),ISNUMBER(F2),E2<F2)"}},
+		{"G", ColumnLimit{Min: "2", Max: "24.5"}, []string{"AND(ISNUMBER(G2),G2<2)", "AND(ISNUMBER(G2),G2>24.5)"}},
+		{"G", ColumnLimit{Max: "HP_set_point"}, nil},
+	}
+
+	for _, test := range tests {
+		result := limitRules(test.column, test.limit, positions)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("limitRules(%s, %+v) = %v, expected %v", test.column, test.limit, result, test.expected)
+		}
+	}
+}
+
+func TestXLSXWriterWideTable(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, nil)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	// 120 columns run past Z and AZ; more rows than are sampled for widths
+	headers := make([]string, 120)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("column_%d", i+1)
+	}
+	headers[119] = "a_much_longer_column_name"
+	if err := w.WriteHeader(headers); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	rowCount := XLSX_WIDTH_SAMPLE_ROWS + 50
+	for r := 1; r <= rowCount; r++ {
+		row := DataRow{}
+		for i, header := range headers {
+			row[header] = r*1000 + i + 1
+		}
+		row["column_2"] = strings.Repeat("x", 30)
+		if err := w.WriteRow(row); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		cell     string
+		expected string
+	}{
+		{"A1", "column_1"},
+		{"Z1", "column_26"},
+		{"AA1", "column_27"},
+		{"AZ1", "column_52"},
+		{"DP1", "a_much_longer_column_name"},
+		{"DQ1", ""},
+		{"AA2", "1027"},
+		{"DP2", "1120"},
+		{fmt.Sprintf("AA%d", XLSX_WIDTH_SAMPLE_ROWS+1), fmt.Sprintf("%d", XLSX_WIDTH_SAMPLE_ROWS*1000+27)},
+		{fmt.Sprintf("DP%d", rowCount+1), fmt.Sprintf("%d", rowCount*1000+120)},
+	}
+	for _, test := range tests {
+		if value, _ := f.GetCellValue(DATA_SHEET, test.cell); value != test.expected {
+			t.Errorf("cell %s = %q, expected %q", test.cell, value, test.expected)
+		}
+	}
+
+	widths := []struct {
+		column   string
+		expected float64
+	}{
+		{"A", 10},
+		{"B", 32},
+		{"AA", 11},
+		{"DP", MAX_HEADER_WIDTH + 2},
+	}
+	for _, test := range widths {
+		if width, _ := f.GetColWidth(DATA_SHEET, test.column); width != test.expected {
+			t.Errorf("GetColWidth(%s) = %v, expected %v", test.column, width, test.expected)
+		}
+	}
+
+	expectedFilter := fmt.Sprintf("'Data'!$A$1:$DP$%d", rowCount+1)
+	filtered := false
+	for _, name := range f.GetDefinedName() {
+		if name.Name == "_xlnm._FilterDatabase" && name.RefersTo == expectedFilter {
+			filtered = true
+		}
+	}
+	if !filtered {
+		t.Errorf("GetDefinedName() = %+v, expected an autofilter on %s", f.GetDefinedName(), expectedFilter)
+	}
+}
+
+func TestColumnWidths(t *testing.T) {
+	at := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		label    string
+		style    string
+		values   []interface{}
+		expected float64
+	}{
+		{"id", "", []interface{}{1, 12345}, MIN_COLUMN_WIDTH},
+		{"Date & Time", "datetime", []interface{}{excelize.Cell{Value: at}}, 21},
+		{"Date", "date", []interface{}{excelize.Cell{Value: at}}, 12},
+		{"Time", "time", []interface{}{excelize.Cell{Value: 0.5}}, 10},
+		{"LP Value (bar)", "numfmt:0.00", []interface{}{excelize.Cell{Value: 1234.5}}, 16},
+		{"Pressure", "numfmt:0.00", []interface{}{excelize.Cell{Value: 123456.789}}, 11},
+		{"Faults", "", []interface{}{"Door open fault, HP fault", nil}, 27},
+		{"Note", "", []interface{}{strings.Repeat("x", 100)}, MAX_COLUMN_WIDTH},
+		{"Température", "", []interface{}{"é"}, 13},
+	}
+
+	for _, test := range tests {
+		rows := make([][]interface{}, len(test.values))
+		for i, value := range test.values {
+			rows[i] = []interface{}{value}
+		}
+		result := columnWidths([]string{test.label}, []string{test.style}, rows)
+		if len(result) != 1 || result[0] != test.expected {
+			t.Errorf("columnWidths(%q, %q, %v) = %v, expected %v", test.label, test.style, test.values, result, test.expected)
+		}
+	}
+}
+
+func TestXLSXWriterRollOver(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newXLSXWriter(&buf, nil)
+	if err != nil {
+		t.Fatalf("newXLSXWriter() error: %v", err)
+	}
+	defer w.Close()
+	w.maxRows = 3
+
+	if err := w.WriteHeader([]string{"created_at", "LP_value"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	for i := 1; i <= 7; i++ {
+		if err := w.WriteRow(DataRow{"created_at": fmt.Sprintf("2024-07-01 10:0%d:00", i), "LP_value": float64(i)}); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.WriteCharts(CHARTS_SHEET, []ChartSpec{{Title: "LP", Columns: []string{"LP_value"}}}, false); err != nil {
+		t.Fatalf("WriteCharts() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("OpenReader() error: %v", err)
+	}
+	defer f.Close()
+
+	expectedSheets := []string{"Data", "Data_2", "Data_3", CHARTS_SHEET}
+	if sheets := f.GetSheetList(); !reflect.DeepEqual(sheets, expectedSheets) {
+		t.Errorf("GetSheetList() = %v, expected %v", sheets, expectedSheets)
+	}
+
+	expectedRows := map[string][]string{
+		"Data":   {"1", "2", "3"},
+		"Data_2": {"4", "5", "6"},
+		"Data_3": {"7"},
+	}
+	for sheet, expected := range expectedRows {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatalf("GetRows(%s) error: %v", sheet, err)
+		}
+		if len(rows) != len(expected)+1 || rows[0][1] != "LP_value" {
+			t.Errorf("sheet %s = %v, expected headers and %d rows", sheet, rows, len(expected))
+			continue
+		}
+		for i, value := range expected {
+			if rows[i+1][1] != value {
+				t.Errorf("sheet %s row %d = %v, expected LP_value %s", sheet, i+2, rows[i+1], value)
+			}
+		}
+	}
+
+	// Charts plot the first sheet
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error: %v", err)
+	}
+	for _, file := range archive.File {
+		if !strings.HasPrefix(file.Name, "xl/charts/chart") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if chart := html.UnescapeString(string(content)); !strings.Contains(chart, "'Data'!$B$2:$B$4") {
+			t.Errorf("chart %s does not reference 'Data'!$B$2:$B$4", file.Name)
+		}
+	}
+}
+
+func TestZipWriter(t *testing.T) {
+	registry.set([]Machine{{Table: "GTPL_110", Name: "Cold Store 7"}})
+	defer registry.set(nil)
+
+	var buf bytes.Buffer
+	w := newZipWriter(&buf, EXPORT_FORMATS["csv"], nil, "GTPL_110", "rows")
+	defer w.Close()
+	w.maxRows = 2
+
+	if err := w.WriteHeader([]string{"id", "LP_value"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := w.WriteRow(DataRow{"id": i, "LP_value": float64(i) / 2}); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	expected := map[string]string{
+		"Cold_Store_7_part1.csv": "id,LP_value\n1,0.5\n2,1\n",
+		"Cold_Store_7_part2.csv": "id,LP_value\n3,1.5\n4,2\n",
+		"Cold_Store_7_part3.csv": "id,LP_value\n5,2.5\n",
+	}
+	result := readZipEntries(t, buf.Bytes())
+	delete(result, MANIFEST_NAME)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("zip entries = %v, expected %v", result, expected)
+	}
+
+	// An empty export is one file of headers
+	buf.Reset()
+	empty := newZipWriter(&buf, EXPORT_FORMATS["tsv"], nil, "GTPL_111", "day")
+	if err := empty.WriteHeader([]string{"id", "created_at"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := empty.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	expected = map[string]string{"GTPL_111.tsv": "id\tcreated_at\n"}
+	result = readZipEntries(t, buf.Bytes())
+	delete(result, MANIFEST_NAME)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("zip entries = %v, expected %v", result, expected)
+	}
+}
+
+func TestZipWriterPeriods(t *testing.T) {
+	rows := []DataRow{
+		{"id": 5, "created_at": "2024-08-01 00:10:00"},
+		{"id": 4, "created_at": "2024-07-31 23:50:00"},
+		{"id": 3, "created_at": "2024-07-31 08:00:00"},
+		{"id": 2, "created_at": "2024-07-30 12:00:00"},
+		{"id": 1, "created_at": "2024-07-31 07:00:00"}, // out of created_at order
+	}
+
+	tests := []struct {
+		split    string
+		expected []ZipFileEntry
+	}{
+		{"day", []ZipFileEntry{
+			{Name: "GTPL_110_2024-08-01.csv", Period: "2024-08-01", Rows: 1},
+			{Name: "GTPL_110_2024-07-31.csv", Period: "2024-07-31", Rows: 2},
+			{Name: "GTPL_110_2024-07-30.csv", Period: "2024-07-30", Rows: 1},
+			{Name: "GTPL_110_2024-07-31_2.csv", Period: "2024-07-31", Rows: 1},
+		}},
+		{"month", []ZipFileEntry{
+			{Name: "GTPL_110_2024-08.csv", Period: "2024-08", Rows: 1},
+			{Name: "GTPL_110_2024-07.csv", Period: "2024-07", Rows: 4},
+		}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newZipWriter(&buf, EXPORT_FORMATS["csv"], nil, "GTPL_110", test.split)
+		if err := w.WriteHeader([]string{"id", "created_at"}); err != nil {
+			t.Fatalf("WriteHeader() error: %v", err)
+		}
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatalf("WriteRow() error: %v", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+		w.Close()
+
+		entries := readZipEntries(t, buf.Bytes())
+		var manifest ZipManifest
+		if err := json.Unmarshal([]byte(entries[MANIFEST_NAME]), &manifest); err != nil {
+			t.Fatalf("split=%s manifest error: %v", test.split, err)
+		}
+		if manifest.Table != "GTPL_110" || manifest.Split != test.split || manifest.Format != "csv" || manifest.TotalRows != len(rows) {
+			t.Errorf("split=%s manifest = %+v, expected GTPL_110 csv with %d rows", test.split, manifest, len(rows))
+		}
+		if len(manifest.Files) != len(test.expected) || len(entries) != len(test.expected)+1 {
+			t.Errorf("split=%s files = %+v, expected %+v", test.split, manifest.Files, test.expected)
+			continue
+		}
+
+		for i, file := range manifest.Files {
+			content := entries[file.Name]
+			sum := sha256.Sum256([]byte(content))
+			expected := test.expected[i]
+			expected.Bytes, expected.SHA256 = int64(len(content)), hex.EncodeToString(sum[:])
+			if file != expected || strings.Count(content, "\n") != file.Rows+1 {
+				t.Errorf("split=%s file %d = %+v, expected %+v", test.split, i, file, expected)
+			}
+		}
+	}
+}
+
+func TestZipWriterXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w := newZipWriter(&buf, EXPORT_FORMATS["xlsx"], DEFAULT_PROFILE, "GTPL_110", "day")
+	defer w.Close()
+
+	if err := w.WriteHeader([]string{"id", "created_at", "LP_value"}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	for i, at := range []string{"2024-07-01 10:00:00", "2024-07-01 11:00:00", "2024-07-02 09:00:00"} {
+		if err := w.WriteRow(DataRow{"id": i + 1, "created_at": at, "LP_value": 3.5}); err != nil {
+			t.Fatalf("WriteRow() error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	tests := []struct {
+		name           string
+		expectedRows   int
+		expectedSheets []string
+	}{
+		{"GTPL_110_2024-07-01.xlsx", 3, []string{DATA_SHEET}},
+		{"GTPL_110_2024-07-02.xlsx", 2, []string{DATA_SHEET}},
+	}
+	for _, test := range tests {
+		f, err := excelize.OpenReader(strings.NewReader(entries[test.name]))
+		if err != nil {
+			t.Errorf("OpenReader(%s) error: %v", test.name, err)
+			continue
+		}
+		rows, _ := f.GetRows(DATA_SHEET)
+		if sheets := f.GetSheetList(); len(rows) != test.expectedRows || !reflect.DeepEqual(sheets, test.expectedSheets) {
+			t.Errorf("%s = %d rows in %v, expected %d rows in %v", test.name, len(rows), sheets, test.expectedRows, test.expectedSheets)
+		}
+		f.Close()
+	}
+}
+
+// Read every entry of a ZIP archive by name
+func readZipEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error: %v", err)
+	}
+
+	entries := make(map[string]string, len(archive.File))
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error: %v", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error: %v", file.Name, err)
+		}
+		entries[file.Name] = string(content)
+	}
+	return entries
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Formats an export can be split into
+var SPLIT_FORMATS = []string{"xlsx", "csv", "tsv"}
+
+// Period splits, by the length of the created_at prefix naming the period
+var SPLIT_PERIODS = map[string]int{
+	"day":   len("2006-01-02"),
+	"month": len("2006-01"),
+}
+
+// Name of the manifest entry of split exports
+const MANIFEST_NAME = "manifest.json"
+
+// Wrap a format so that its exports are split into files of a ZIP archive:
+// by row count for split=rows, or by the day or month of created_at
+func splitFormat(format ExportFormat, table, split string) ExportFormat {
+	return ExportFormat{
+		Extension:   "zip",
+		ContentType: "application/zip",
+		NewWriter: func(w io.Writer, labels *HeaderProfile) (RowWriter, error) {
+			return newZipWriter(w, format, labels, table, split), nil
+		},
+	}
+}
+
+// ZipManifest lists the files of a split export
+type ZipManifest struct {
+	Table     string         `json:"table"`
+	Split     string         `json:"split"`
+	Format    string         `json:"format"`
+	CreatedAt time.Time      `json:"createdAt"`
+	TotalRows int            `json:"totalRows"`
+	Files     []ZipFileEntry `json:"files"`
+}
+
+// ZipFileEntry describes one file of a split export. The checksum covers
+// the file's uncompressed content.
+type ZipFileEntry struct {
+	Name   string `json:"name"`
+	Period string `json:"period,omitempty"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// checksumWriter hashes and counts what passes through it
+type checksumWriter struct {
+	w     io.Writer
+	hash  hash.Hash
+	bytes int64
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.bytes += int64(n)
+	return n, err
+}
+
+// zipWriter splits an export into files of one format, written as entries
+// of a ZIP archive followed by a manifest. A file is finished before the
+// next one starts, so only one is held at a time.
+type zipWriter struct {
+	zw       *zip.Writer
+	format   ExportFormat
+	labels   *HeaderProfile
+	table    string
+	name     string
+	split    string
+	maxRows  int // rows per file for split=rows
+	period   int // created_at prefix length for period splits
+	manifest ZipManifest
+
+	headers []string
+	types   map[string]string
+	loc     *time.Location
+
+	part     RowWriter // current file, nil until it is needed
+	entry    ZipFileEntry
+	checksum *checksumWriter
+	seen     map[string]int // files started per period
+}
+
+// Create a writer splitting a table's export as split=rows, day or month
+func newZipWriter(w io.Writer, format ExportFormat, labels *HeaderProfile, table, split string) *zipWriter {
+	z := &zipWriter{
+		zw:     zip.NewWriter(w),
+		format: format,
+		labels: labels,
+		table:  table,
+		name:   exportBaseName(table),
+		split:  split,
+		period: SPLIT_PERIODS[split],
+		seen:   make(map[string]int),
+	}
+	if split == "rows" {
+		z.maxRows = SHEET_ROW_LIMIT
+	}
+	return z
+}
+
+// SetColumnTypes keeps the column types for every file's writer
+func (z *zipWriter) SetColumnTypes(types map[string]string) {
+	z.types = types
+}
+
+// SetLocation keeps the timestamp zone for every file's writer
+func (z *zipWriter) SetLocation(loc *time.Location) {
+	z.loc = loc
+}
+
+func (z *zipWriter) WriteHeader(headers []string) error {
+	z.headers = headers
+	return nil
+}
+
+func (z *zipWriter) WriteRow(row DataRow) error {
+	period := z.rowPeriod(row)
+	if z.part == nil || (z.maxRows > 0 && z.entry.Rows >= z.maxRows) || (z.period > 0 && period != z.entry.Period) {
+		if err := z.nextPart(period); err != nil {
+			return err
+		}
+	}
+	z.entry.Rows++
+	return z.part.WriteRow(row)
+}
+
+// Get the period of a row from its created_at. Rows without one stay in the
+// current file.
+func (z *zipWriter) rowPeriod(row DataRow) string {
+	if z.period == 0 {
+		return ""
+	}
+	at := formatTextValue(row["created_at"])
+	if len(at) < z.period {
+		if z.part != nil {
+			return z.entry.Period
+		}
+		return "undated"
+	}
+	return at[:z.period]
+}
+
+// Get the name of the next file. Periods are named after their start; a
+// period met again, when rows are not in created_at order, gets a numbered
+// second file.
+func (z *zipWriter) partName(period string) string {
+	if z.period == 0 {
+		return fmt.Sprintf("%s_part%d.%s", z.name, len(z.manifest.Files)+1, z.format.Extension)
+	}
+	if period == "" {
+		return fmt.Sprintf("%s.%s", z.name, z.format.Extension)
+	}
+
+	z.seen[period]++
+	if n := z.seen[period]; n > 1 {
+		return fmt.Sprintf("%s_%s_%d.%s", z.name, period, n, z.format.Extension)
+	}
+	return fmt.Sprintf("%s_%s.%s", z.name, period, z.format.Extension)
+}
+
+// Finish the current file and start the next one with the same headers
+func (z *zipWriter) nextPart(period string) error {
+	if err := z.finishPart(); err != nil {
+		return err
+	}
+
+	z.entry = ZipFileEntry{Name: z.partName(period), Period: period}
+	entry, err := z.zw.Create(z.entry.Name)
+	if err != nil {
+		return fmt.Errorf("error creating zip entry: %v", err)
+	}
+	z.checksum = &checksumWriter{w: entry, hash: sha256.New()}
+
+	part, err := z.format.NewWriter(z.checksum, z.labels)
+	if err != nil {
+		return err
+	}
+	if tw, ok := part.(columnTypeSetter); ok && z.types != nil {
+		tw.SetColumnTypes(z.types)
+	}
+	if lw, ok := part.(locationSetter); ok && z.loc != nil {
+		lw.SetLocation(z.loc)
+	}
+
+	z.part = part
+	return part.WriteHeader(z.headers)
+}
+
+// Write out the current file, if any, and record it in the manifest
+func (z *zipWriter) finishPart() error {
+	if z.part == nil {
+		return nil
+	}
+	part := z.part
+	z.part = nil
+
+	if err := part.Flush(); err != nil {
+		part.Close()
+		return err
+	}
+	if err := part.Close(); err != nil {
+		return err
+	}
+
+	z.entry.Bytes = z.checksum.bytes
+	z.entry.SHA256 = hex.EncodeToString(z.checksum.hash.Sum(nil))
+	z.manifest.Files = append(z.manifest.Files, z.entry)
+	z.manifest.TotalRows += z.entry.Rows
+	return nil
+}
+
+// Get the current file, starting the first one for an empty export
+func (z *zipWriter) current() (RowWriter, error) {
+	if z.part == nil {
+		if err := z.nextPart(""); err != nil {
+			return nil, err
+		}
+	}
+	return z.part, nil
+}
+
+func (z *zipWriter) Flush() error {
+	// An empty export still gets a file with its headers
+	if len(z.manifest.Files) == 0 {
+		if _, err := z.current(); err != nil {
+			return err
+		}
+	}
+	if err := z.finishPart(); err != nil {
+		return err
+	}
+
+	z.manifest.Table, z.manifest.Split, z.manifest.Format = z.table, z.split, z.format.Extension
+	z.manifest.CreatedAt = time.Now()
+	entry, err := z.zw.Create(MANIFEST_NAME)
+	if err != nil {
+		return fmt.Errorf("error creating zip entry: %v", err)
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(z.manifest); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return z.zw.Close()
+}
+
+func (z *zipWriter) Close() error {
+	if z.part != nil {
+		return z.part.Close()
+	}
+	return nil
+}